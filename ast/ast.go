@@ -2,6 +2,8 @@ package ast
 
 import (
 	"bytes"
+	"math/big"
+	"strconv"
 	"strings"
 
 	"github.com/danielrs/monkey/token"
@@ -12,6 +14,11 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+
+	// Pos and End return the span of the node: the position of its first
+	// character and the position right after its last one.
+	Pos() token.Position
+	End() token.Position
 }
 
 type Statement interface {
@@ -38,6 +45,18 @@ func (p *Program) TokenLiteral() string {
 		return ""
 	}
 }
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+func (p *Program) End() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[len(p.Statements)-1].End()
+	}
+	return token.Position{}
+}
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -52,8 +71,22 @@ type LetStatement struct {
 	Value      Expression
 }
 
+// IsConst reports whether the statement declares a constant, which is the
+// case when it starts with const instead of let.
+func (ls *LetStatement) IsConst() bool { return ls.Token.Type == token.CONST }
+
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Pos }
+func (ls *LetStatement) End() token.Position {
+	if ls.Value != nil {
+		return ls.Value.End()
+	}
+	if ls.Identifier != nil {
+		return ls.Identifier.End()
+	}
+	return ls.Token.End
+}
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
@@ -73,6 +106,8 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Pos }
+func (i *Identifier) End() token.Position  { return i.Token.End }
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -84,6 +119,13 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Pos }
+func (rs *ReturnStatement) End() token.Position {
+	if rs.Value != nil {
+		return rs.Value.End()
+	}
+	return rs.Token.End
+}
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(rs.Token.Literal + " ")
@@ -101,6 +143,18 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position {
+	if es.Expression != nil {
+		return es.Expression.Pos()
+	}
+	return es.Token.Pos
+}
+func (es *ExpressionStatement) End() token.Position {
+	if es.Expression != nil {
+		return es.Expression.End()
+	}
+	return es.Token.End
+}
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -111,11 +165,25 @@ func (es *ExpressionStatement) String() string {
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	Big   *big.Int // value of literals too large for an int64, or nil
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Pos }
+func (il *IntegerLiteral) End() token.Position  { return il.Token.End }
+
+type FloatLiteral struct {
+	Token token.Token
+	Value float64
+}
+
+func (fl *FloatLiteral) expressionNode()      {}
+func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
+func (fl *FloatLiteral) Pos() token.Position  { return fl.Token.Pos }
+func (fl *FloatLiteral) End() token.Position  { return fl.Token.End }
 
 type BooleanLiteral struct {
 	Token token.Token
@@ -125,6 +193,8 @@ type BooleanLiteral struct {
 func (bl *BooleanLiteral) expressionNode()      {}
 func (bl *BooleanLiteral) TokenLiteral() string { return bl.Token.Literal }
 func (bl *BooleanLiteral) String() string       { return bl.Token.Literal }
+func (bl *BooleanLiteral) Pos() token.Position  { return bl.Token.Pos }
+func (bl *BooleanLiteral) End() token.Position  { return bl.Token.End }
 
 type PrefixExpression struct {
 	Token    token.Token
@@ -139,10 +209,19 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
-func (sl *StringLiteral) String() string       { return `"` + sl.Value + `"` }
+func (sl *StringLiteral) String() string       { return strconv.Quote(sl.Value) }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Pos }
+func (sl *StringLiteral) End() token.Position  { return sl.Token.End }
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Pos }
+func (pe *PrefixExpression) End() token.Position {
+	if pe.Right != nil {
+		return pe.Right.End()
+	}
+	return pe.Token.End
+}
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -161,6 +240,18 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *InfixExpression) End() token.Position {
+	if ie.Right != nil {
+		return ie.Right.End()
+	}
+	return ie.Token.End
+}
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -180,6 +271,16 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Pos() token.Position  { return ie.Token.Pos }
+func (ie *IfExpression) End() token.Position {
+	if ie.Alternative != nil {
+		return ie.Alternative.End()
+	}
+	if ie.Consequence != nil {
+		return ie.Consequence.End()
+	}
+	return ie.Token.End
+}
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if")
@@ -196,12 +297,23 @@ func (ie *IfExpression) String() string {
 }
 
 type BlockStatement struct {
-	Token      token.Token
+	Token      token.Token // The '{' token
 	Statements []Statement
+	Rbrace     token.Token // The '}' token
 }
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BlockStatement) End() token.Position {
+	if bs.Rbrace.End.IsValid() {
+		return bs.Rbrace.End
+	}
+	if len(bs.Statements) > 0 {
+		return bs.Statements[len(bs.Statements)-1].End()
+	}
+	return bs.Token.End
+}
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -210,14 +322,91 @@ func (bs *BlockStatement) String() string {
 	return out.String()
 }
 
+// WhileStatement runs Body for as long as Condition is truthy.
+type WhileStatement struct {
+	Token     token.Token
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode()       {}
+func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Pos() token.Position  { return ws.Token.Pos }
+func (ws *WhileStatement) End() token.Position {
+	if ws.Body != nil {
+		return ws.Body.End()
+	}
+	return ws.Token.End
+}
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("while")
+	out.WriteString(ws.Condition.String())
+	out.WriteString(" ")
+	out.WriteString(ws.Body.String())
+	return out.String()
+}
+
+// ForStatement runs Body once for each element of Iterable, with the
+// element bound to Variable.
+type ForStatement struct {
+	Token    token.Token
+	Variable *Identifier
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForStatement) statementNode()       {}
+func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Pos() token.Position  { return fs.Token.Pos }
+func (fs *ForStatement) End() token.Position {
+	if fs.Body != nil {
+		return fs.Body.End()
+	}
+	return fs.Token.End
+}
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("for (")
+	out.WriteString(fs.Variable.String())
+	out.WriteString(" in ")
+	out.WriteString(fs.Iterable.String())
+	out.WriteString(") ")
+	out.WriteString(fs.Body.String())
+	return out.String()
+}
+
+// BranchStatement is a break or continue statement, which ends the
+// innermost loop or skips to its next iteration.
+type BranchStatement struct {
+	Token token.Token
+}
+
+// IsBreak reports whether the statement is a break statement.
+func (bs *BranchStatement) IsBreak() bool { return bs.Token.Type == token.BREAK }
+
+func (bs *BranchStatement) statementNode()       {}
+func (bs *BranchStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BranchStatement) Pos() token.Position  { return bs.Token.Pos }
+func (bs *BranchStatement) End() token.Position  { return bs.Token.End }
+func (bs *BranchStatement) String() string       { return bs.Token.Literal + ";" }
+
 type FunctionLiteral struct {
 	Token      token.Token
+	Name       string // set when the literal is bound with let
 	Parameters ParameterList
 	Body       *BlockStatement
 }
 
 func (fe *FunctionLiteral) expressionNode()      {}
 func (fe *FunctionLiteral) TokenLiteral() string { return fe.Token.Literal }
+func (fe *FunctionLiteral) Pos() token.Position  { return fe.Token.Pos }
+func (fe *FunctionLiteral) End() token.Position {
+	if fe.Body != nil {
+		return fe.Body.End()
+	}
+	return fe.Token.End
+}
 func (fe *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -241,12 +430,20 @@ func (pl ParameterList) String() string {
 }
 
 type ArrayLiteral struct {
-	Token    token.Token
+	Token    token.Token // The '[' token
 	Elements []Expression
+	Rbracket token.Token // The ']' token
 }
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Pos }
+func (al *ArrayLiteral) End() token.Position {
+	if al.Rbracket.End.IsValid() {
+		return al.Rbracket.End
+	}
+	return al.Token.End
+}
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -262,19 +459,89 @@ func (al *ArrayLiteral) String() string {
 	return out.String()
 }
 
+// AssignExpression assigns to a variable that is already bound, which
+// can't be a constant. Operator is = or a compound operator like +=, which
+// applies its operator to the old value and the new one.
+type AssignExpression struct {
+	Token    token.Token // The operator token
+	Name     *Identifier
+	Operator string
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode()      {}
+func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Pos() token.Position  { return ae.Name.Pos() }
+func (ae *AssignExpression) End() token.Position {
+	if ae.Value != nil {
+		return ae.Value.End()
+	}
+	return ae.Token.End
+}
+func (ae *AssignExpression) String() string {
+	return "(" + ae.Name.String() + " " + ae.Operator + " " + ae.Value.String() + ")"
+}
+
+// InterpolatedString is a string with embedded expressions. Its parts
+// alternate between the pieces of text, as *StringLiteral, and the
+// expressions, so the text is at even indexes and there is text at both
+// ends, maybe empty.
+type InterpolatedString struct {
+	Token token.Token // The INTERP_START token
+	Parts []Expression
+	Close token.Token // The INTERP_END token
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Pos }
+func (is *InterpolatedString) End() token.Position {
+	if is.Close.End.IsValid() {
+		return is.Close.End
+	}
+	return is.Token.End
+}
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(`"`)
+	for i, part := range is.Parts {
+		if i%2 == 1 {
+			out.WriteString("${" + part.String() + "}")
+			continue
+		}
+		if text, ok := part.(*StringLiteral); ok {
+			quoted := strconv.Quote(text.Value)
+			out.WriteString(strings.Replace(quoted[1:len(quoted)-1], "$", `\$`, -1))
+		}
+	}
+	out.WriteString(`"`)
+
+	return out.String()
+}
+
 type IndexExpression struct {
-	Token token.Token
-	Left  Expression
-	Index Expression
+	Token    token.Token // The '[' token
+	Left     Expression
+	Index    Expression
+	Rbracket token.Token // The ']' token
 }
 
 type HashLiteral struct {
-	Token token.Token
-	Pairs map[Expression]Expression
+	Token  token.Token // The '{' token
+	Pairs  map[Expression]Expression
+	Rbrace token.Token // The '}' token
 }
 
 func (hl *HashLiteral) expressionNode()      {}
 func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) Pos() token.Position  { return hl.Token.Pos }
+func (hl *HashLiteral) End() token.Position {
+	if hl.Rbrace.End.IsValid() {
+		return hl.Rbrace.End
+	}
+	return hl.Token.End
+}
 func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
@@ -292,6 +559,21 @@ func (hl *HashLiteral) String() string {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Pos() token.Position {
+	if ie.Left != nil {
+		return ie.Left.Pos()
+	}
+	return ie.Token.Pos
+}
+func (ie *IndexExpression) End() token.Position {
+	if ie.Rbracket.End.IsValid() {
+		return ie.Rbracket.End
+	}
+	if ie.Index != nil {
+		return ie.Index.End()
+	}
+	return ie.Token.End
+}
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 
@@ -304,14 +586,75 @@ func (ie *IndexExpression) String() string {
 	return out.String()
 }
 
+// SliceExpression is left[low:high], where either bound can be left
+// out, making it nil.
+type SliceExpression struct {
+	Token    token.Token // The '[' token
+	Left     Expression
+	Low      Expression
+	High     Expression
+	Rbracket token.Token // The ']' token
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) Pos() token.Position {
+	if se.Left != nil {
+		return se.Left.Pos()
+	}
+	return se.Token.Pos
+}
+func (se *SliceExpression) End() token.Position {
+	if se.Rbracket.End.IsValid() {
+		return se.Rbracket.End
+	}
+	if se.High != nil {
+		return se.High.End()
+	}
+	if se.Low != nil {
+		return se.Low.End()
+	}
+	return se.Token.End
+}
+func (se *SliceExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(se.Left.String())
+	out.WriteString("[")
+	if se.Low != nil {
+		out.WriteString(se.Low.String())
+	}
+	out.WriteString(":")
+	if se.High != nil {
+		out.WriteString(se.High.String())
+	}
+	out.WriteString("])")
+
+	return out.String()
+}
+
 type CallExpression struct {
 	Token     token.Token // The ''(' token
 	Function  Expression  // identifier or function literal
 	Arguments []Expression
+	Rparen    token.Token // The ')' token
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Pos() token.Position {
+	if ce.Function != nil {
+		return ce.Function.Pos()
+	}
+	return ce.Token.Pos
+}
+func (ce *CallExpression) End() token.Position {
+	if ce.Rparen.End.IsValid() {
+		return ce.Rparen.End
+	}
+	return ce.Token.End
+}
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -327,3 +670,192 @@ func (ce *CallExpression) String() string {
 
 	return out.String()
 }
+
+// MatchExpression evaluates to the body of the first arm whose pattern
+// matches Value and whose guard, if it has one, is truthy.
+type MatchExpression struct {
+	Token  token.Token // The 'match' token
+	Value  Expression
+	Arms   []*MatchArm
+	Rbrace token.Token // The '}' token
+}
+
+// MatchArm is an arm of a match expression, "pattern if guard => body".
+// Guard is nil when the arm has none.
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression
+	Body    Expression
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Pos }
+func (me *MatchExpression) End() token.Position {
+	if me.Rbrace.End.IsValid() {
+		return me.Rbrace.End
+	}
+	return me.Token.End
+}
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+
+	arms := make([]string, 0, len(me.Arms))
+	for _, arm := range me.Arms {
+		s := arm.Pattern.String()
+		if arm.Guard != nil {
+			s += " if " + arm.Guard.String()
+		}
+		arms = append(arms, s+" => "+arm.Body.String())
+	}
+
+	out.WriteString("match")
+	out.WriteString(me.Value.String())
+	out.WriteString(" {")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Pattern is the pattern of a match arm. Patterns are written like the
+// literals of the values they match.
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+// LiteralPattern matches the values equal to a literal number, string or
+// boolean. Value is the literal, or a negated number.
+type LiteralPattern struct {
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode()         {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Value.TokenLiteral() }
+func (lp *LiteralPattern) Pos() token.Position  { return lp.Value.Pos() }
+func (lp *LiteralPattern) End() token.Position  { return lp.Value.End() }
+func (lp *LiteralPattern) String() string       { return lp.Value.String() }
+
+// BindingPattern matches any value, binding it to Name. The name _ is a
+// wildcard, which binds nothing.
+type BindingPattern struct {
+	Name *Identifier
+}
+
+// IsWildcard reports whether the pattern is the wildcard _.
+func (bp *BindingPattern) IsWildcard() bool { return bp.Name.Value == "_" }
+
+func (bp *BindingPattern) patternNode()         {}
+func (bp *BindingPattern) TokenLiteral() string { return bp.Name.TokenLiteral() }
+func (bp *BindingPattern) Pos() token.Position  { return bp.Name.Pos() }
+func (bp *BindingPattern) End() token.Position  { return bp.Name.End() }
+func (bp *BindingPattern) String() string       { return bp.Name.String() }
+
+// ArrayPattern matches the arrays whose elements match Elements. Without
+// a Rest name the arrays must have as many elements as there are
+// patterns; with one, they can have more, which are bound to Rest as an
+// array.
+type ArrayPattern struct {
+	Token    token.Token // The '[' token
+	Elements []Pattern
+	Rest     *Identifier // The name after '...', or nil
+	Rbracket token.Token // The ']' token
+}
+
+func (ap *ArrayPattern) patternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Pos }
+func (ap *ArrayPattern) End() token.Position {
+	if ap.Rbracket.End.IsValid() {
+		return ap.Rbracket.End
+	}
+	return ap.Token.End
+}
+func (ap *ArrayPattern) String() string {
+	elements := make([]string, 0, len(ap.Elements)+1)
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern matches the hashes that have all of Keys, with values
+// matching the pattern in Values at the same index. The hashes can have
+// other keys too. Keys are literals, like the values of LiteralPattern.
+type HashPattern struct {
+	Token  token.Token // The '{' token
+	Keys   []Expression
+	Values []Pattern
+	Rbrace token.Token // The '}' token
+}
+
+func (hp *HashPattern) patternNode()         {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Pos }
+func (hp *HashPattern) End() token.Position {
+	if hp.Rbrace.End.IsValid() {
+		return hp.Rbrace.End
+	}
+	return hp.Token.End
+}
+func (hp *HashPattern) String() string {
+	pairs := make([]string, 0, len(hp.Keys))
+	for i, key := range hp.Keys {
+		pairs = append(pairs, key.String()+": "+hp.Values[i].String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// Bindings returns the names bound by a pattern, in the order they are
+// bound when it matches. Wildcards are left out.
+func Bindings(p Pattern) []*Identifier {
+	var names []*Identifier
+	Inspect(p, func(n Node) bool {
+		switch n := n.(type) {
+		case *BindingPattern:
+			if !n.IsWildcard() {
+				names = append(names, n.Name)
+			}
+			return false
+		case *ArrayPattern:
+			for _, el := range n.Elements {
+				names = append(names, Bindings(el)...)
+			}
+			if n.Rest != nil && n.Rest.Value != "_" {
+				names = append(names, n.Rest)
+			}
+			return false
+		}
+		return true
+	})
+	return names
+}
+
+// Placeholders for code that couldn't be parsed. They span the skipped
+// source so tools can still report on it.
+
+type BadStatement struct {
+	From token.Position
+	To   token.Position
+}
+
+func (bs *BadStatement) statementNode()       {}
+func (bs *BadStatement) TokenLiteral() string { return "" }
+func (bs *BadStatement) String() string       { return "<bad statement>" }
+func (bs *BadStatement) Pos() token.Position  { return bs.From }
+func (bs *BadStatement) End() token.Position  { return bs.To }
+
+type BadExpression struct {
+	From token.Position
+	To   token.Position
+}
+
+func (be *BadExpression) expressionNode()      {}
+func (be *BadExpression) TokenLiteral() string { return "" }
+func (be *BadExpression) String() string       { return "<bad expression>" }
+func (be *BadExpression) Pos() token.Position  { return be.From }
+func (be *BadExpression) End() token.Position  { return be.To }
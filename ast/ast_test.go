@@ -27,3 +27,49 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong, got %q", program.String())
 	}
 }
+
+func TestInspect(t *testing.T) {
+	// let x = -(1 + y); with a bad statement after it.
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Token:      token.Token{Type: token.LET, Literal: "let"},
+				Identifier: &Identifier{Value: "x"},
+				Value: &PrefixExpression{
+					Operator: "-",
+					Right: &InfixExpression{
+						Left:     &IntegerLiteral{Value: 1},
+						Operator: "+",
+						Right:    &Identifier{Value: "y"},
+					},
+				},
+			},
+			&BadStatement{},
+		},
+	}
+
+	var visited []string
+	Inspect(program, func(n Node) bool {
+		switch n := n.(type) {
+		case *Identifier:
+			visited = append(visited, n.Value)
+		case *InfixExpression:
+			visited = append(visited, n.Operator)
+			return false
+		case *BadStatement:
+			visited = append(visited, "bad")
+		}
+		return true
+	})
+
+	expected := []string{"x", "+", "bad"}
+	if len(visited) != len(expected) {
+		t.Fatalf("visited %v, want %v", visited, expected)
+	}
+	for i := range expected {
+		if visited[i] != expected[i] {
+			t.Errorf("visited %v, want %v", visited, expected)
+			break
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package ast
+
+// Inspect traverses the AST in depth-first order, calling f for every
+// node. If f returns false the children of that node are skipped. Missing
+// children are never passed to f.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+
+	case *LetStatement:
+		if n.Identifier != nil {
+			Inspect(n.Identifier, f)
+		}
+		Inspect(n.Value, f)
+
+	case *ReturnStatement:
+		Inspect(n.Value, f)
+
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+
+	case *WhileStatement:
+		Inspect(n.Condition, f)
+		if n.Body != nil {
+			Inspect(n.Body, f)
+		}
+
+	case *ForStatement:
+		if n.Variable != nil {
+			Inspect(n.Variable, f)
+		}
+		Inspect(n.Iterable, f)
+		if n.Body != nil {
+			Inspect(n.Body, f)
+		}
+
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		if n.Consequence != nil {
+			Inspect(n.Consequence, f)
+		}
+		if n.Alternative != nil {
+			Inspect(n.Alternative, f)
+		}
+
+	case *FunctionLiteral:
+		for _, p := range n.Parameters {
+			Inspect(p, f)
+		}
+		if n.Body != nil {
+			Inspect(n.Body, f)
+		}
+
+	case *ArrayLiteral:
+		for _, e := range n.Elements {
+			Inspect(e, f)
+		}
+
+	case *HashLiteral:
+		for k, v := range n.Pairs {
+			Inspect(k, f)
+			Inspect(v, f)
+		}
+
+	case *AssignExpression:
+		Inspect(n.Name, f)
+		Inspect(n.Value, f)
+
+	case *InterpolatedString:
+		for _, p := range n.Parts {
+			Inspect(p, f)
+		}
+
+	case *MatchExpression:
+		Inspect(n.Value, f)
+		for _, arm := range n.Arms {
+			Inspect(arm.Pattern, f)
+			Inspect(arm.Guard, f)
+			Inspect(arm.Body, f)
+		}
+
+	case *LiteralPattern:
+		Inspect(n.Value, f)
+
+	case *BindingPattern:
+		Inspect(n.Name, f)
+
+	case *ArrayPattern:
+		for _, el := range n.Elements {
+			Inspect(el, f)
+		}
+		if n.Rest != nil {
+			Inspect(n.Rest, f)
+		}
+
+	case *HashPattern:
+		for i, k := range n.Keys {
+			Inspect(k, f)
+			Inspect(n.Values[i], f)
+		}
+
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+
+	case *SliceExpression:
+		Inspect(n.Left, f)
+		if n.Low != nil {
+			Inspect(n.Low, f)
+		}
+		if n.High != nil {
+			Inspect(n.High, f)
+		}
+
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	}
+}
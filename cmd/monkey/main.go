@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	"github.com/danielrs/monkey/repl"
+)
+
+var engine = flag.String("engine", repl.EngineEval,
+	"engine that runs the code: "+repl.EngineEval+" or "+repl.EngineVM)
+
+var strict = flag.Bool("strict", false,
+	"make indexes and slice bounds out of range errors")
+
+func main() {
+	user, err := user.Current()
+	if err != nil {
+		panic(err)
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [file]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *engine != repl.EngineEval && *engine != repl.EngineVM {
+		fmt.Fprintf(os.Stderr, "unknown engine %q\n", *engine)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if flag.NArg() == 1 {
+		// Tries to read from file.
+		data, err := ioutil.ReadFile(flag.Arg(0))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if !repl.RunFile(flag.Arg(0), string(data), *engine, *strict, os.Stdout, os.Stderr) {
+			os.Exit(1)
+		}
+	} else {
+		// Runs REPL.
+		fmt.Printf("Hello %s!\n", user.Username)
+		fmt.Printf("This is the Monkey programming language!\n")
+		fmt.Printf("Feel free to type in commands\n")
+		repl.Start(os.Stdin, os.Stdout, *engine, *strict)
+	}
+
+}
@@ -0,0 +1,295 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/danielrs/monkey/ast"
+)
+
+// Instructions is a flat sequence of opcodes followed by their operands.
+// Operands are stored big-endian.
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d",
+			len(operands), len(def.OperandWidths))
+	}
+
+	out := def.Name
+	for _, o := range operands {
+		out += fmt.Sprintf(" %d", o)
+	}
+	return out
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpDup
+
+	// Literals.
+	OpTrue
+	OpFalse
+	OpNull
+	OpArray
+	OpHash
+	OpInterpolate
+
+	// Operators.
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpEqual
+	OpNotEqual
+	OpLessThan
+	OpGreaterThan
+	OpLessEqual
+	OpGreaterEqual
+	OpMinus
+	OpBang
+	OpIndex
+	OpSlice // value[low:high], with null for the bounds left out
+
+	// Jumps. OpAnd and OpOr leave the value on the stack when they jump
+	// and pop it otherwise.
+	OpJump
+	OpJumpNotTruthy
+	OpAnd
+	OpOr
+
+	// Iteration. OpIter replaces an iterable with an iterator over it.
+	// OpIterNext pushes the next element of the iterator on top of the
+	// stack, or pops the iterator and jumps when there are no more.
+	OpIter
+	OpIterNext
+
+	// Pattern matching. OpMatch matches the value on top of the stack
+	// against the pattern in the given constant, pushing the values it
+	// binds in reverse order, or jumps if it doesn't match. OpNoMatch
+	// fails for the value on top of the stack. OpEnterScope starts the
+	// scope of an arm with the given number of locals, and OpLeaveScope
+	// ends it.
+	OpMatch
+	OpNoMatch
+	OpEnterScope
+	OpLeaveScope
+
+	// Variables.
+	OpGetGlobal
+	OpSetGlobal
+	OpGetLocal
+	OpSetLocal
+	OpGetOuter
+	OpSetOuter
+	OpGetBuiltin
+
+	// Functions. OpTailCall is a call whose result is returned right
+	// away, so the new frame replaces the one making it.
+	OpClosure
+	OpCall
+	OpTailCall
+	OpReturnValue
+
+	// OpError fails with the message in the given constant, for errors
+	// found when compiling that the evaluator reports when running.
+	OpError
+)
+
+// Definition describes an opcode: its name for debugging and the width in
+// bytes of each of its operands.
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup:      {"OpDup", []int{}},
+
+	OpTrue:        {"OpTrue", []int{}},
+	OpFalse:       {"OpFalse", []int{}},
+	OpNull:        {"OpNull", []int{}},
+	OpArray:       {"OpArray", []int{2}},
+	OpHash:        {"OpHash", []int{2}},
+	OpInterpolate: {"OpInterpolate", []int{2}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLessThan:     {"OpLessThan", []int{}},
+	OpGreaterThan:  {"OpGreaterThan", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpMinus:        {"OpMinus", []int{}},
+	OpBang:         {"OpBang", []int{}},
+	OpIndex:        {"OpIndex", []int{}},
+	OpSlice:        {"OpSlice", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpAnd:           {"OpAnd", []int{2}},
+	OpOr:            {"OpOr", []int{2}},
+
+	OpIter:     {"OpIter", []int{}},
+	OpIterNext: {"OpIterNext", []int{2}},
+
+	OpMatch:   {"OpMatch", []int{2, 2}},
+	OpNoMatch: {"OpNoMatch", []int{}},
+
+	OpEnterScope: {"OpEnterScope", []int{1}},
+	OpLeaveScope: {"OpLeaveScope", []int{}},
+
+	OpGetGlobal:  {"OpGetGlobal", []int{2}},
+	OpSetGlobal:  {"OpSetGlobal", []int{2}},
+	OpGetLocal:   {"OpGetLocal", []int{1}},
+	OpSetLocal:   {"OpSetLocal", []int{1}},
+	OpGetOuter:   {"OpGetOuter", []int{1, 1}},
+	OpSetOuter:   {"OpSetOuter", []int{1, 1}},
+	OpGetBuiltin: {"OpGetBuiltin", []int{2}},
+
+	OpClosure:     {"OpClosure", []int{2}},
+	OpCall:        {"OpCall", []int{1}},
+	OpTailCall:    {"OpTailCall", []int{1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+
+	OpError: {"OpError", []int{2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make encodes an instruction. It returns an empty slice for unknown
+// opcodes.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	length := 1
+	for _, w := range def.OperandWidths {
+		length += w
+	}
+
+	instruction := make([]byte, length)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction, returning them and
+// the number of bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// SourceMap maps instructions back to the nodes they were compiled from,
+// so errors can point to the source. Each entry covers the instructions
+// from its offset up to the offset of the next one.
+
+type SourceMap []SourceMapping
+
+type SourceMapping struct {
+	Offset int
+	Node   ast.Node
+}
+
+// Add maps the instructions starting at offset to node.
+func (m SourceMap) Add(offset int, node ast.Node) SourceMap {
+	if n := len(m); n > 0 {
+		if m[n-1].Node == node {
+			return m
+		}
+		if m[n-1].Offset == offset {
+			m[n-1].Node = node
+			return m
+		}
+	}
+	return append(m, SourceMapping{Offset: offset, Node: node})
+}
+
+// Lookup returns the node the instruction at offset was compiled from, or
+// nil if it is unknown.
+func (m SourceMap) Lookup(offset int) ast.Node {
+	i := sort.Search(len(m), func(i int) bool {
+		return m[i].Offset > offset
+	})
+	if i == 0 {
+		return nil
+	}
+	return m[i-1].Node
+}
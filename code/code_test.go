@@ -0,0 +1,119 @@
+package code
+
+import (
+	"testing"
+
+	"github.com/danielrs/monkey/ast"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpGetOuter, []int{1, 2}, []byte{byte(OpGetOuter), 1, 2}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+		if string(instruction) != string(tt.expected) {
+			t.Errorf("Make(%d, %v) is %v, want %v",
+				tt.op, tt.operands, instruction, tt.expected)
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpGetLocal, 1),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpGetOuter, 1, 255),
+	}
+
+	expected := `0000 OpAdd
+0001 OpGetLocal 1
+0003 OpConstant 2
+0006 OpConstant 65535
+0009 OpGetOuter 1 255
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions are\n%s\nwant\n%s", concatted, expected)
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpGetOuter, []int{3, 4}, 2},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %s", err)
+		}
+
+		operands, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Errorf("bytes read is %d, want %d", n, tt.bytesRead)
+		}
+		for i, want := range tt.operands {
+			if operands[i] != want {
+				t.Errorf("operand %d is %d, want %d", i, operands[i], want)
+			}
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	a := &ast.Identifier{Value: "a"}
+	b := &ast.Identifier{Value: "b"}
+
+	var m SourceMap
+	m = m.Add(0, a)
+	m = m.Add(3, a)
+	m = m.Add(4, b)
+
+	if len(m) != 2 {
+		t.Fatalf("len(m) is %d, want 2", len(m))
+	}
+
+	tests := []struct {
+		offset   int
+		expected ast.Node
+	}{
+		{0, a},
+		{3, a},
+		{4, b},
+		{100, b},
+	}
+
+	for _, tt := range tests {
+		if node := m.Lookup(tt.offset); node != tt.expected {
+			t.Errorf("m.Lookup(%d) is %v, want %v", tt.offset, node, tt.expected)
+		}
+	}
+
+	if node := SourceMap(nil).Lookup(0); node != nil {
+		t.Errorf("empty source map returned %v", node)
+	}
+}
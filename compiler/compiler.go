@@ -0,0 +1,880 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/danielrs/monkey/ast"
+	"github.com/danielrs/monkey/code"
+	"github.com/danielrs/monkey/object"
+)
+
+// Compiler lowers the AST to bytecode for the virtual machine.
+//
+// Every expression leaves exactly one value on the stack. Statements only
+// leave one when their value is needed, which is the case for the last
+// statement of a block or program; let statements leave nil, like in the
+// evaluator.
+//
+// Names bound with let are defined before the code of their function (or
+// program, for loop or match arm) is compiled, so functions can refer to
+// names that are bound after them, as they can when evaluating the AST.
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	// literals are the indexes of the constants that are literal values,
+	// so each value is only added once.
+	literals map[interface{}]int
+
+	scopes     []compilationScope
+	scopeIndex int
+
+	// node is the node being compiled, recorded in the source map of
+	// every instruction emitted for it.
+	node ast.Node
+
+	// err is the first instruction emitted with an operand too large for
+	// it, which Compile returns once done.
+	err error
+}
+
+// compilationScope holds the instructions of the function being compiled.
+type compilationScope struct {
+	instructions code.Instructions
+	sourceMap    code.SourceMap
+
+	// loops are the loops being compiled, innermost last.
+	loops []*loop
+}
+
+// loop is where break and continue statements in a loop jump to. The
+// target of breaks is only known once the whole loop is compiled, so
+// they are patched then.
+type loop struct {
+	start  int
+	breaks []int
+}
+
+// Bytecode is the result of compiling a program.
+
+type Bytecode struct {
+	Instructions code.Instructions
+	SourceMap    code.SourceMap
+	Constants    []object.Object
+}
+
+// Returns a pointer to a new Compiler.
+func New() *Compiler {
+	return NewWithState(NewSymbolTable(), []object.Object{})
+}
+
+// NewWithState returns a Compiler that keeps defining globals and constants
+// on top of the given ones, which is what the REPL needs.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	literals := make(map[interface{}]int)
+	for i, obj := range constants {
+		if key, ok := literalKey(obj); ok {
+			literals[key] = i
+		}
+	}
+	return &Compiler{
+		constants:   constants,
+		symbolTable: s,
+		literals:    literals,
+		scopes:      []compilationScope{{}},
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+		Constants:    c.constants,
+	}
+}
+
+// Compile compiles the given node, which should be a program or an
+// expression.
+func (c *Compiler) Compile(node ast.Node) (err error) {
+	prev := c.node
+	c.node = node
+	defer func() {
+		c.node = prev
+		if err == nil {
+			err = c.err
+		}
+	}()
+
+	switch node := node.(type) {
+	case *ast.Program:
+		c.hoist(node)
+		return c.compileStatements(node.Statements, true)
+
+	case *ast.BadExpression:
+		return fmt.Errorf("%s: cannot compile expression with syntax errors", node.Pos())
+
+	case *ast.BooleanLiteral:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.IntegerLiteral:
+		var integer object.Object = &object.Integer{Value: node.Value}
+		if node.Big != nil {
+			integer = &object.BigInteger{Value: node.Big}
+		}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.InterpolatedString:
+		for _, part := range node.Parts {
+			if err := c.Compile(part); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpInterpolate, len(node.Parts))
+
+	case *ast.Identifier:
+		c.loadSymbol(node.Value)
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		// Sorts the keys so the output doesn't depend on map order.
+		keys := make([]ast.Expression, 0, len(node.Pairs))
+		for k := range node.Pairs {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+
+		for _, k := range keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs))
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{node.Low, node.High} {
+			if bound == nil {
+				c.emit(code.OpNull)
+			} else if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpSlice)
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		return c.compileInfixExpression(node)
+
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node, c.compileBlock)
+
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(node, func(body ast.Expression) error {
+			return c.Compile(body)
+		})
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+
+	case *ast.CallExpression:
+		return c.compileCallExpression(node, code.OpCall)
+
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+// compileStatements compiles a list of statements. If keep is true the
+// value of the last one is left on the stack, or nil if there are none.
+func (c *Compiler) compileStatements(stmts []ast.Statement, keep bool) error {
+	for i, s := range stmts {
+		if err := c.compileStatement(s, keep && i == len(stmts)-1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) compileBlock(block *ast.BlockStatement) error {
+	if len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+	return c.compileStatements(block.Statements, true)
+}
+
+func (c *Compiler) compileStatement(stmt ast.Statement, keep bool) error {
+	prev := c.node
+	c.node = stmt
+	defer func() { c.node = prev }()
+
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		if err := c.Compile(stmt.Expression); err != nil {
+			return err
+		}
+		if !keep {
+			c.emit(code.OpPop)
+		}
+
+	case *ast.LetStatement:
+		if err := c.Compile(stmt.Value); err != nil {
+			return err
+		}
+		c.storeSymbol(c.define(stmt))
+		if keep {
+			c.emit(code.OpNull)
+		}
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(stmt.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.WhileStatement:
+		if err := c.compileWhileStatement(stmt); err != nil {
+			return err
+		}
+		if keep {
+			c.emit(code.OpNull)
+		}
+
+	case *ast.ForStatement:
+		if err := c.compileForStatement(stmt); err != nil {
+			return err
+		}
+		if keep {
+			c.emit(code.OpNull)
+		}
+
+	case *ast.BranchStatement:
+		loops := c.scopes[c.scopeIndex].loops
+		if len(loops) == 0 {
+			return fmt.Errorf("%s: %s is not in a loop", stmt.Pos(), stmt.TokenLiteral())
+		}
+		l := loops[len(loops)-1]
+		if stmt.IsBreak() {
+			l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+		} else {
+			c.emit(code.OpJump, l.start)
+		}
+
+	case *ast.BadStatement:
+		return fmt.Errorf("%s: cannot compile statement with syntax errors", stmt.Pos())
+
+	default:
+		return fmt.Errorf("cannot compile %T", stmt)
+	}
+
+	return nil
+}
+
+// compileTailBlock compiles the body of a function, or a branch of an if
+// expression in it, like the evaluator's evalTailBlock: calls in tail
+// position are compiled as tail calls, which are the values of return
+// statements and, when tail is true, the value of the last statement.
+func (c *Compiler) compileTailBlock(block *ast.BlockStatement, tail bool) error {
+	if len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+	for i, s := range block.Statements {
+		last := i == len(block.Statements)-1
+		if err := c.compileTailStatement(s, last, tail && last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) compileTailStatement(stmt ast.Statement, keep, tail bool) error {
+	prev := c.node
+	c.node = stmt
+	defer func() { c.node = prev }()
+
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement:
+		if err := c.compileTailExpression(stmt.Value, true); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+		return nil
+
+	case *ast.ExpressionStatement:
+		if err := c.compileTailExpression(stmt.Expression, tail); err != nil {
+			return err
+		}
+		if !keep {
+			c.emit(code.OpPop)
+		}
+		return nil
+	}
+
+	return c.compileStatement(stmt, keep)
+}
+
+func (c *Compiler) compileTailExpression(expr ast.Expression, tail bool) error {
+	prev := c.node
+	c.node = expr
+	defer func() { c.node = prev }()
+
+	switch expr := expr.(type) {
+	case *ast.IfExpression:
+		// Return statements in the branches are in tail position even
+		// when the if expression isn't.
+		return c.compileIfExpression(expr, func(block *ast.BlockStatement) error {
+			return c.compileTailBlock(block, tail)
+		})
+
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(expr, func(body ast.Expression) error {
+			return c.compileTailExpression(body, tail)
+		})
+
+	case *ast.CallExpression:
+		if tail {
+			return c.compileCallExpression(expr, code.OpTailCall)
+		}
+	}
+
+	return c.Compile(expr)
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	"<":  code.OpLessThan,
+	">":  code.OpGreaterThan,
+	"<=": code.OpLessEqual,
+	">=": code.OpGreaterEqual,
+}
+
+func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+
+	// Logical operators only evaluate the right side when needed, and
+	// result in the last side evaluated.
+	if node.Operator == "&&" || node.Operator == "||" {
+		op := code.OpAnd
+		if node.Operator == "||" {
+			op = code.OpOr
+		}
+		jump := c.emit(op, 9999)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.changeOperand(jump, len(c.currentInstructions()))
+		return nil
+	}
+
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+
+	op, ok := infixOperators[node.Operator]
+	if !ok {
+		return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+	}
+	c.emit(op)
+
+	return nil
+}
+
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+	if !ok {
+		// Builtins can't be assigned either.
+		c.emitError("cannot assign to undeclared variable: %s", node.Name.Value)
+		return nil
+	}
+
+	// Names are defined before their let statements run, so the variable
+	// is loaded first, which fails when it isn't bound yet.
+	c.loadSymbol(node.Name.Value)
+	if symbol.Constant {
+		c.emit(code.OpPop)
+		c.emitError("cannot assign to constant: %s", node.Name.Value)
+		return nil
+	}
+
+	if node.Operator == "=" {
+		c.emit(code.OpPop)
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+	} else {
+		op, ok := infixOperators[strings.TrimSuffix(node.Operator, "=")]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator %s", node.Pos(), node.Operator)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(op)
+	}
+	c.emit(code.OpDup)
+	c.storeSymbol(symbol)
+
+	return nil
+}
+
+// compileIfExpression compiles an if expression, compiling its branches
+// with compileBlock.
+func (c *Compiler) compileIfExpression(node *ast.IfExpression, compileBlock func(*ast.BlockStatement) error) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+	if err := compileBlock(node.Consequence); err != nil {
+		return err
+	}
+	jump := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := compileBlock(node.Alternative); err != nil {
+		return err
+	}
+	c.changeOperand(jump, len(c.currentInstructions()))
+
+	return nil
+}
+
+func (c *Compiler) compileWhileStatement(stmt *ast.WhileStatement) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(stmt.Condition); err != nil {
+		return err
+	}
+	exit := c.emit(code.OpJumpNotTruthy, 9999)
+
+	l, err := c.compileLoopBody(start, stmt.Body)
+	if err != nil {
+		return err
+	}
+
+	c.changeOperand(exit, len(c.currentInstructions()))
+	c.patchBreaks(l, len(c.currentInstructions()))
+	return nil
+}
+
+// compileForStatement compiles a for loop, which keeps an iterator on the
+// stack while it runs. The variable of the loop, and the names bound in
+// its body, are in a scope of their own.
+func (c *Compiler) compileForStatement(stmt *ast.ForStatement) error {
+	if err := c.Compile(stmt.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+
+	scope := c.emit(code.OpEnterScope, 0)
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	variable := c.symbolTable.Define(stmt.Variable.Value)
+	c.hoist(stmt.Body)
+
+	start := c.emit(code.OpIterNext, 9999)
+	c.storeSymbol(variable)
+
+	l, err := c.compileLoopBody(start, stmt.Body)
+	if err != nil {
+		return err
+	}
+
+	// Breaking out leaves the iterator behind, while running out of
+	// elements already popped it.
+	c.patchBreaks(l, c.emit(code.OpPop))
+	c.changeOperand(start, len(c.currentInstructions()))
+	c.emit(code.OpLeaveScope)
+	c.changeOperand(scope, c.symbolTable.numDefinitions)
+	return nil
+}
+
+// compileLoopBody compiles the body of a loop starting at start, followed
+// by a jump back to it.
+func (c *Compiler) compileLoopBody(start int, body *ast.BlockStatement) (*loop, error) {
+	// Functions in the body add scopes, which may move this one.
+	l := &loop{start: start}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, l)
+	defer func() {
+		loops := c.scopes[c.scopeIndex].loops
+		c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+	}()
+
+	if err := c.compileStatements(body.Statements, false); err != nil {
+		return nil, err
+	}
+	c.emit(code.OpJump, start)
+
+	return l, nil
+}
+
+func (c *Compiler) patchBreaks(l *loop, target int) {
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, target)
+	}
+}
+
+// compileMatchExpression compiles the arms of a match expression one after
+// the other, each jumping to the next one when it doesn't match, and their
+// bodies with compileBody. The value being matched stays on the stack
+// until an arm is chosen.
+func (c *Compiler) compileMatchExpression(node *ast.MatchExpression, compileBody func(ast.Expression) error) error {
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+
+	var ends []int
+	for _, arm := range node.Arms {
+		pattern := c.addConstant(&object.Pattern{Pattern: arm.Pattern})
+		next := c.emit(code.OpMatch, pattern, 9999)
+
+		end, err := c.compileMatchArm(arm, compileBody)
+		if err != nil {
+			return err
+		}
+		ends = append(ends, end)
+
+		c.changeOperand(next, len(c.currentInstructions()))
+	}
+	c.emit(code.OpNoMatch)
+
+	for _, pos := range ends {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+
+	return nil
+}
+
+// compileMatchArm compiles an arm whose pattern matched, in a scope of its
+// own holding the names the pattern binds, and returns the position of
+// the jump out of the match expression. When the guard fails, the arm
+// leaves its scope and falls through to the next one.
+func (c *Compiler) compileMatchArm(arm *ast.MatchArm, compileBody func(ast.Expression) error) (int, error) {
+	scope := c.emit(code.OpEnterScope, 0)
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+	defer func() { c.symbolTable = c.symbolTable.Outer }()
+
+	for _, name := range ast.Bindings(arm.Pattern) {
+		c.storeSymbol(c.symbolTable.Define(name.Value))
+	}
+	if arm.Guard != nil {
+		c.hoist(arm.Guard)
+	}
+	c.hoist(arm.Body)
+
+	guard := -1
+	if arm.Guard != nil {
+		if err := c.Compile(arm.Guard); err != nil {
+			return 0, err
+		}
+		guard = c.emit(code.OpJumpNotTruthy, 9999)
+	}
+
+	c.emit(code.OpPop)
+	if err := compileBody(arm.Body); err != nil {
+		return 0, err
+	}
+	c.emit(code.OpLeaveScope)
+	end := c.emit(code.OpJump, 9999)
+
+	if guard >= 0 {
+		c.changeOperand(guard, len(c.currentInstructions()))
+		c.emit(code.OpLeaveScope)
+	}
+	c.changeOperand(scope, c.symbolTable.numDefinitions)
+
+	return end, nil
+}
+
+// compileCallExpression compiles a call made with op, which is OpCall or
+// OpTailCall.
+func (c *Compiler) compileCallExpression(node *ast.CallExpression, op code.Opcode) error {
+	if len(node.Arguments) > 255 {
+		return fmt.Errorf("%s: too many arguments in call", node.Pos())
+	}
+	if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+	for _, a := range node.Arguments {
+		if err := c.Compile(a); err != nil {
+			return err
+		}
+	}
+	c.emit(op, len(node.Arguments))
+	return nil
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	c.enterScope()
+
+	for _, p := range node.Parameters {
+		c.symbolTable.Define(p.Value)
+	}
+	c.hoist(node.Body)
+
+	if err := c.compileTailBlock(node.Body, true); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	numLocals := c.symbolTable.numDefinitions
+	scope := c.leaveScope()
+
+	fn := &object.CompiledFunction{
+		Name:          node.Name,
+		Instructions:  scope.instructions,
+		SourceMap:     scope.sourceMap,
+		NumLocals:     numLocals,
+		NumParameters: len(node.Parameters),
+		Parameters:    node.Parameters,
+		Body:          node.Body,
+	}
+	c.emit(code.OpClosure, c.addConstant(fn))
+
+	return nil
+}
+
+// hoist defines every name bound with let in the given node, without
+// looking into nested functions, for loops or match arms.
+func (c *Compiler) hoist(node ast.Node) {
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral:
+			return false
+		case *ast.LetStatement:
+			if n.Identifier != nil {
+				c.define(n)
+			}
+		// For loops and match arms have scopes of their own, hoisted
+		// when they are compiled.
+		case *ast.ForStatement:
+			c.hoist(n.Iterable)
+			return false
+		case *ast.MatchExpression:
+			c.hoist(n.Value)
+			return false
+		}
+		return true
+	})
+}
+
+// define defines the name bound by a let or const statement.
+func (c *Compiler) define(stmt *ast.LetStatement) Symbol {
+	if stmt.IsConst() {
+		return c.symbolTable.DefineConstant(stmt.Identifier.Value)
+	}
+	return c.symbolTable.Define(stmt.Identifier.Value)
+}
+
+// loadSymbol pushes the value of the given name. Names that are not
+// defined anywhere are looked up between the builtins when the code runs.
+func (c *Compiler) loadSymbol(name string) {
+	symbol, ok := c.symbolTable.Resolve(name)
+	if !ok {
+		c.emit(code.OpGetBuiltin, c.addConstant(&object.String{Value: name}))
+		return
+	}
+
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, symbol.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, symbol.Index)
+	case OuterScope:
+		c.emit(code.OpGetOuter, symbol.Depth, symbol.Index)
+	}
+}
+
+// storeSymbol pops the top of the stack into the given symbol.
+func (c *Compiler) storeSymbol(symbol Symbol) {
+	switch symbol.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, symbol.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, symbol.Index)
+	case OuterScope:
+		c.emit(code.OpSetOuter, symbol.Depth, symbol.Index)
+	}
+}
+
+// emitError emits an instruction that fails with the given message when
+// it runs, leaving a value on the stack as far as the compiler is
+// concerned.
+func (c *Compiler) emitError(format string, args ...interface{}) {
+	msg := &object.String{Value: fmt.Sprintf(format, args...)}
+	c.emit(code.OpError, c.addConstant(msg))
+}
+
+// Helper functions.
+
+// addConstant adds obj to the constants, unless it is a literal value
+// that is there already, and returns its index.
+func (c *Compiler) addConstant(obj object.Object) int {
+	key, literal := literalKey(obj)
+	if literal {
+		if i, ok := c.literals[key]; ok {
+			return i
+		}
+	}
+
+	c.constants = append(c.constants, obj)
+	if literal {
+		c.literals[key] = len(c.constants) - 1
+	}
+	return len(c.constants) - 1
+}
+
+// Keys of the literal values in the constants. Floats are kept by their
+// bits, so 0.0 and -0.0 stay apart.
+type (
+	floatKey uint64
+	bigKey   string
+)
+
+// literalKey returns the key obj is found by between the constants, or
+// false if it isn't a literal value.
+func literalKey(obj object.Object) (interface{}, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return *obj, true
+	case *object.BigInteger:
+		return bigKey(obj.Value.String()), true
+	case *object.Float:
+		return floatKey(math.Float64bits(obj.Value)), true
+	case *object.String:
+		return *obj, true
+	}
+	return nil, false
+}
+
+// emit appends an instruction to the current scope and returns its
+// position.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+
+	scope := &c.scopes[c.scopeIndex]
+	pos := len(scope.instructions)
+	if c.node != nil {
+		scope.sourceMap = scope.sourceMap.Add(pos, c.node)
+	}
+	scope.instructions = append(scope.instructions, code.Make(op, operands...)...)
+	return pos
+}
+
+// checkOperands records an error if an operand doesn't fit in its width,
+// as it would be cut short.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	if c.err != nil {
+		return
+	}
+
+	def, _ := code.Lookup(byte(op))
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		if o < 0 || o >= 1<<(8*width) {
+			var pos string
+			if c.node != nil {
+				pos = c.node.Pos().String() + ": "
+			}
+			c.err = fmt.Errorf("%stoo large to compile: operand %d of %s doesn't fit in %d bytes",
+				pos, o, def.Name, width)
+			return
+		}
+	}
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+// changeOperand replaces the last operand of the instruction at pos,
+// which is how jumps get their target once it is known.
+func (c *Compiler) changeOperand(pos int, operand int) {
+	ins := c.currentInstructions()
+	op := code.Opcode(ins[pos])
+	def, _ := code.Lookup(byte(op))
+	operands, _ := code.ReadOperands(def, ins[pos+1:])
+	operands[len(operands)-1] = operand
+	c.checkOperands(op, operands)
+	copy(ins[pos:], code.Make(op, operands...))
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, compilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() compilationScope {
+	scope := c.scopes[c.scopeIndex]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return scope
+}
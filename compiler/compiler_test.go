@@ -0,0 +1,551 @@
+package compiler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/danielrs/monkey/ast"
+	"github.com/danielrs/monkey/code"
+	"github.com/danielrs/monkey/lexer"
+	"github.com/danielrs/monkey/object"
+	"github.com/danielrs/monkey/parser"
+)
+
+type compilerTestCase struct {
+	input                string
+	expectedConstants    []interface{}
+	expectedInstructions []code.Instructions
+}
+
+func TestExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+			},
+		},
+		{
+			input:             `[1, "1", 1, "1", 2]`,
+			expectedConstants: []interface{}{1, "1", 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 2),
+				code.Make(code.OpArray, 5),
+			},
+		},
+		{
+			input:             "1; 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+			},
+		},
+		{
+			input:             `-1 < "a"`,
+			expectedConstants: []interface{}{1, "a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMinus),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpLessThan),
+			},
+		},
+		{
+			input:             "true && false",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpTrue),
+				code.Make(code.OpAnd, 5),
+				code.Make(code.OpFalse),
+			},
+		},
+		{
+			input:             "[1][0]",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpArray, 1),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpIndex),
+			},
+		},
+		{
+			input:             `"ab"[:-1]`,
+			expectedConstants: []interface{}{"ab", 1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpNull),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpMinus),
+				code.Make(code.OpSlice),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 }; 20",
+			expectedConstants: []interface{}{10, 20},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 10),
+				// 0004
+				code.Make(code.OpConstant, 0),
+				// 0007
+				code.Make(code.OpJump, 11),
+				// 0010
+				code.Make(code.OpNull),
+				// 0011
+				code.Make(code.OpPop),
+				// 0012
+				code.Make(code.OpConstant, 1),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "while (true) { if (false) { break }; continue }; 1",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpTrue),
+				// 0001
+				code.Make(code.OpJumpNotTruthy, 22),
+				// 0004
+				code.Make(code.OpFalse),
+				// 0005
+				code.Make(code.OpJumpNotTruthy, 14),
+				// 0008
+				code.Make(code.OpJump, 22),
+				// 0011
+				code.Make(code.OpJump, 15),
+				// 0014
+				code.Make(code.OpNull),
+				// 0015
+				code.Make(code.OpPop),
+				// 0016
+				code.Make(code.OpJump, 0),
+				// 0019
+				code.Make(code.OpJump, 0),
+				// 0022
+				code.Make(code.OpConstant, 0),
+			},
+		},
+		{
+			input:             "for (x in [1]) { break }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpArray, 1),
+				// 0006
+				code.Make(code.OpIter),
+				// 0007
+				code.Make(code.OpEnterScope, 1),
+				// 0009
+				code.Make(code.OpIterNext, 21),
+				// 0012
+				code.Make(code.OpSetLocal, 0),
+				// 0014
+				code.Make(code.OpJump, 20),
+				// 0017
+				code.Make(code.OpJump, 9),
+				// 0020
+				code.Make(code.OpPop),
+				// 0021
+				code.Make(code.OpLeaveScope),
+				// 0022
+				code.Make(code.OpNull),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "match (1) { x if x => x }",
+			expectedConstants: []interface{}{
+				1,
+				&ast.BindingPattern{Name: &ast.Identifier{Value: "x"}},
+			},
+			expectedInstructions: []code.Instructions{
+				// 0000
+				code.Make(code.OpConstant, 0),
+				// 0003
+				code.Make(code.OpMatch, 1, 25),
+				// 0008
+				code.Make(code.OpEnterScope, 1),
+				// 0010
+				code.Make(code.OpSetLocal, 0),
+				// 0012
+				code.Make(code.OpGetLocal, 0),
+				// 0014
+				code.Make(code.OpJumpNotTruthy, 24),
+				// 0017
+				code.Make(code.OpPop),
+				// 0018
+				code.Make(code.OpGetLocal, 0),
+				// 0020
+				code.Make(code.OpLeaveScope),
+				// 0021
+				code.Make(code.OpJump, 26),
+				// 0024
+				code.Make(code.OpLeaveScope),
+				// 0025
+				code.Make(code.OpNoMatch),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestLetStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let one = 1; let two = one; two",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpSetGlobal, 1),
+				code.Make(code.OpGetGlobal, 1),
+			},
+		},
+		{
+			input:             "len",
+			expectedConstants: []interface{}{"len"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpGetBuiltin, 0),
+			},
+		},
+		{
+			input:             "let a = 1; a += 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpAdd),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input:             "let a = 1; a = 2",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			input:             "x -= 1",
+			expectedConstants: []interface{}{"cannot assign to undeclared variable: x"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpError, 0),
+			},
+		},
+		{
+			input:             "const a = 1; a = 2",
+			expectedConstants: []interface{}{1, "cannot assign to constant: a"},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpPop),
+				code.Make(code.OpError, 1),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "fn(f) { if (f(0)) { f(1) } else { 1 + f(2) } }",
+			expectedConstants: []interface{}{
+				0, 1, 2,
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpJumpNotTruthy, 20),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpTailCall, 1),
+					code.Make(code.OpJump, 31),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpCall, 1),
+					code.Make(code.OpAdd),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 3),
+			},
+		},
+		{
+			input: "fn(a) { let b = a; fn() { b } }",
+			expectedConstants: []interface{}{
+				[]code.Instructions{
+					code.Make(code.OpGetOuter, 1, 1),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpGetLocal, 0),
+					code.Make(code.OpSetLocal, 1),
+					code.Make(code.OpClosure, 0),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 1),
+			},
+		},
+		{
+			input: "fn(a) { fn() { a *= 2 } }",
+			expectedConstants: []interface{}{
+				2,
+				[]code.Instructions{
+					code.Make(code.OpGetOuter, 1, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpMul),
+					code.Make(code.OpDup),
+					code.Make(code.OpSetOuter, 1, 0),
+					code.Make(code.OpReturnValue),
+				},
+				[]code.Instructions{
+					code.Make(code.OpClosure, 1),
+					code.Make(code.OpReturnValue),
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 2),
+			},
+		},
+		{
+			input: "fn() { }()",
+			expectedConstants: []interface{}{[]code.Instructions{
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			}},
+			expectedInstructions: []code.Instructions{
+				code.Make(code.OpClosure, 0),
+				code.Make(code.OpCall, 0),
+			},
+		},
+	}
+
+	runCompilerTests(t, tests)
+}
+
+func TestHoisting(t *testing.T) {
+	// f is defined before the function referring to it is compiled.
+	input := "let g = fn() { f() }; let f = fn() { 1 };"
+
+	program := parse(input)
+	c := New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	g := c.Bytecode().Constants[0].(*object.CompiledFunction)
+	expected := concatInstructions([]code.Instructions{
+		code.Make(code.OpGetGlobal, 1),
+		code.Make(code.OpTailCall, 0),
+		code.Make(code.OpReturnValue),
+	})
+	if g.Instructions.String() != expected.String() {
+		t.Errorf("instructions of g are\n%s\nwant\n%s", g.Instructions, expected)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	var constants, elements, locals, body strings.Builder
+	for i := 0; i < 70000; i++ {
+		fmt.Fprintf(&constants, "%d;", i)
+		elements.WriteString("0,")
+		body.WriteString("0;")
+	}
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&locals, "let x%c%c = %d;", 'a'+i/26, 'a'+i%26, i)
+	}
+
+	inputs := []string{
+		constants.String(),
+		"[" + elements.String() + "0]",
+		"fn() {" + locals.String() + "}",
+		"if (true) {" + body.String() + "} else { 1 }",
+	}
+
+	for _, input := range inputs {
+		c := New()
+		err := c.Compile(parse(input))
+		if err == nil {
+			t.Errorf("compiling %.20q... succeeded", input)
+			continue
+		}
+		if !strings.Contains(err.Error(), "too large to compile") {
+			t.Errorf("compiling %.20q... failed with %q", input, err)
+		}
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("b")
+
+	nested := NewEnclosedSymbolTable(local)
+	nested.Define("c")
+	nested.Define("c")
+
+	tests := []struct {
+		name     string
+		expected Symbol
+	}{
+		{"a", Symbol{Name: "a", Scope: GlobalScope, Index: 0}},
+		{"b", Symbol{Name: "b", Scope: OuterScope, Index: 0, Depth: 1}},
+		{"c", Symbol{Name: "c", Scope: LocalScope, Index: 0}},
+	}
+
+	for _, tt := range tests {
+		symbol, ok := nested.Resolve(tt.name)
+		if !ok {
+			t.Errorf("name %s not resolvable", tt.name)
+			continue
+		}
+		if symbol != tt.expected {
+			t.Errorf("%s resolved to %+v, want %+v", tt.name, symbol, tt.expected)
+		}
+	}
+
+	if _, ok := nested.Resolve("d"); ok {
+		t.Errorf("name d resolved")
+	}
+	if nested.numDefinitions != 1 {
+		t.Errorf("nested.numDefinitions is %d, want 1", nested.numDefinitions)
+	}
+}
+
+// Helper functions for testing.
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func runCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		c := New()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := c.Bytecode()
+
+		expected := concatInstructions(tt.expectedInstructions)
+		if bytecode.Instructions.String() != expected.String() {
+			t.Errorf("instructions of %q are\n%s\nwant\n%s",
+				tt.input, bytecode.Instructions, expected)
+		}
+
+		testConstants(t, tt.input, tt.expectedConstants, bytecode.Constants)
+	}
+}
+
+func testConstants(t *testing.T, input string, expected []interface{}, actual []object.Object) {
+	t.Helper()
+
+	if len(expected) != len(actual) {
+		t.Errorf("%q has %d constants, want %d", input, len(actual), len(expected))
+		return
+	}
+
+	for i, constant := range expected {
+		switch constant := constant.(type) {
+		case int:
+			integer, ok := actual[i].(*object.Integer)
+			if !ok || integer.Value != int64(constant) {
+				t.Errorf("constant %d of %q is %s, want %d",
+					i, input, actual[i].Inspect(), constant)
+			}
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok || str.Value != constant {
+				t.Errorf("constant %d of %q is %s, want %q",
+					i, input, actual[i].Inspect(), constant)
+			}
+		case ast.Pattern:
+			pattern, ok := actual[i].(*object.Pattern)
+			if !ok || pattern.Inspect() != constant.String() {
+				t.Errorf("constant %d of %q is %s, want pattern %s",
+					i, input, actual[i].Inspect(), constant)
+			}
+		case []code.Instructions:
+			fn, ok := actual[i].(*object.CompiledFunction)
+			if !ok {
+				t.Errorf("constant %d of %q is %T, want *object.CompiledFunction",
+					i, input, actual[i])
+				continue
+			}
+			want := concatInstructions(constant)
+			if fn.Instructions.String() != want.String() {
+				t.Errorf("instructions of constant %d of %q are\n%s\nwant\n%s",
+					i, input, fn.Instructions, want)
+			}
+		}
+	}
+}
+
+func concatInstructions(s []code.Instructions) code.Instructions {
+	out := code.Instructions{}
+	for _, ins := range s {
+		out = append(out, ins...)
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope  SymbolScope = "LOCAL"
+	OuterScope  SymbolScope = "OUTER"
+)
+
+// Symbol is a resolved name. Outer symbols are locals of an enclosing
+// function, Depth functions away from the one being compiled. Constant
+// symbols can't be assigned to.
+
+type Symbol struct {
+	Name     string
+	Scope    SymbolScope
+	Index    int
+	Depth    int
+	Constant bool
+}
+
+// SymbolTable holds the names defined in a function, or at the top level
+// when it has no outer table.
+
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	return &SymbolTable{store: s}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define adds name to the table. Defining a name twice returns the same
+// symbol, so rebinding it with let reuses its slot; if the name was
+// defined as a constant it stops being one.
+func (s *SymbolTable) Define(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		symbol.Constant = false
+		s.store[name] = symbol
+		return symbol
+	}
+
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	if s.Outer == nil {
+		symbol.Scope = GlobalScope
+	} else {
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// DefineConstant is like Define, but the symbol is a constant, unless the
+// name is also defined with Define.
+func (s *SymbolTable) DefineConstant(name string) Symbol {
+	if symbol, ok := s.store[name]; ok {
+		return symbol
+	}
+	symbol := s.Define(name)
+	symbol.Constant = true
+	s.store[name] = symbol
+	return symbol
+}
+
+// Resolve looks name up in this table and then in the enclosing ones.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	depth := 0
+	for t := s; t != nil; t = t.Outer {
+		if symbol, ok := t.store[name]; ok {
+			if symbol.Scope == GlobalScope || depth == 0 {
+				return symbol, true
+			}
+			symbol.Scope = OuterScope
+			symbol.Depth = depth
+			return symbol, true
+		}
+		depth++
+	}
+	return Symbol{}, false
+}
@@ -0,0 +1,127 @@
+package diagnostic
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/danielrs/monkey/token"
+)
+
+// Severity tells how bad a diagnostic is.
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Error:
+		return "error"
+	case Warning:
+		return "warning"
+	}
+	return "unknown"
+}
+
+// Code identifies the kind of a diagnostic, so tools can match on it
+// without parsing the message.
+
+type Code string
+
+// Lexer codes.
+const (
+	UnterminatedString Code = "L0001"
+	InvalidEscape      Code = "L0002"
+	InvalidUTF8        Code = "L0003"
+)
+
+// Parser codes.
+const (
+	UnexpectedToken    Code = "P0001"
+	ExpectedExpression Code = "P0002"
+	InvalidLiteral     Code = "P0003"
+	InvalidAssignment  Code = "P0004"
+	InvalidLoopControl Code = "P0005"
+	InvalidPattern     Code = "P0006"
+)
+
+// Diagnostic is a message about a span of the source. Expected and Found
+// are only set when the problem is an unexpected token.
+
+type Diagnostic struct {
+	Severity Severity
+	Code     Code
+	Message  string
+	Pos      token.Position
+	End      token.Position
+	Expected []token.TokenType
+	Found    token.TokenType
+}
+
+// Error returns the diagnostic in a single line, as in
+// "file:1:2: error[P0001]: message".
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s[%s]: %s", d.Pos, d.Severity, d.Code, d.Message)
+}
+
+func (d *Diagnostic) String() string {
+	return d.Error()
+}
+
+// Render returns the diagnostic followed by the line of src it points to,
+// with the offending span underlined by carets. src must be the same input
+// that was given to the lexer.
+func (d *Diagnostic) Render(src string) string {
+	var out bytes.Buffer
+
+	fmt.Fprintf(&out, "%s[%s]: %s\n", d.Severity, d.Code, d.Message)
+	if !d.Pos.IsValid() {
+		return out.String()
+	}
+
+	lineNo := strconv.Itoa(d.Pos.Line)
+	gutter := strings.Repeat(" ", len(lineNo))
+	fmt.Fprintf(&out, "%s--> %s\n", gutter, d.Pos)
+
+	offset := d.Pos.Offset
+	if offset > len(src) {
+		offset = len(src)
+	}
+	start := strings.LastIndexByte(src[:offset], '\n') + 1
+	end := strings.IndexByte(src[offset:], '\n')
+	if end < 0 {
+		end = len(src)
+	} else {
+		end += offset
+	}
+	line := strings.TrimRight(src[start:end], "\r")
+
+	// Keeps tabs in the padding so the carets line up with the source,
+	// and pads every character with one space whatever its length in
+	// bytes.
+	var padding bytes.Buffer
+	for _, ch := range src[start:offset] {
+		if ch == '\t' {
+			padding.WriteByte('\t')
+		} else {
+			padding.WriteByte(' ')
+		}
+	}
+
+	width := 1
+	if d.End.Line == d.Pos.Line && d.End.Offset > d.Pos.Offset && d.End.Offset <= len(src) {
+		width = utf8.RuneCountInString(src[d.Pos.Offset:d.End.Offset])
+	}
+
+	fmt.Fprintf(&out, "%s |\n", gutter)
+	fmt.Fprintf(&out, "%s | %s\n", lineNo, line)
+	fmt.Fprintf(&out, "%s | %s%s\n", gutter, padding.String(), strings.Repeat("^", width))
+
+	return out.String()
+}
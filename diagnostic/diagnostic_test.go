@@ -0,0 +1,120 @@
+package diagnostic
+
+import (
+	"testing"
+
+	"github.com/danielrs/monkey/token"
+)
+
+func TestError(t *testing.T) {
+	d := &Diagnostic{
+		Severity: Error,
+		Code:     UnexpectedToken,
+		Message:  "expected next token to be ), got EOF",
+		Pos:      token.Position{Filename: "test.monkey", Offset: 14, Line: 1, Column: 15},
+	}
+
+	expected := "test.monkey:1:15: error[P0001]: expected next token to be ), got EOF"
+	if d.Error() != expected {
+		t.Errorf("d.Error() is %q, want %q", d.Error(), expected)
+	}
+}
+
+func TestRender(t *testing.T) {
+	src := "let x = 1;\n\tlet y = foo + ;\nlet z = 3;"
+
+	tests := []struct {
+		diagnostic *Diagnostic
+		expected   string
+	}{
+		{
+			&Diagnostic{
+				Severity: Error,
+				Code:     ExpectedExpression,
+				Message:  "no prefix parse function found for ;",
+				Pos:      token.Position{Offset: 25, Line: 2, Column: 15},
+				End:      token.Position{Offset: 26, Line: 2, Column: 16},
+			},
+			"error[P0002]: no prefix parse function found for ;\n" +
+				" --> 2:15\n" +
+				"  |\n" +
+				"2 | \tlet y = foo + ;\n" +
+				"  | \t             ^\n",
+		},
+		{
+			&Diagnostic{
+				Severity: Warning,
+				Code:     InvalidLiteral,
+				Message:  "something about foo",
+				Pos:      token.Position{Filename: "a.monkey", Offset: 19, Line: 2, Column: 9},
+				End:      token.Position{Filename: "a.monkey", Offset: 22, Line: 2, Column: 12},
+			},
+			"warning[P0003]: something about foo\n" +
+				" --> a.monkey:2:9\n" +
+				"  |\n" +
+				"2 | \tlet y = foo + ;\n" +
+				"  | \t       ^^^\n",
+		},
+		{
+			&Diagnostic{
+				Severity: Error,
+				Code:     UnexpectedToken,
+				Message:  "expected next token to be ;, got EOF",
+				Pos:      token.Position{Offset: 38, Line: 3, Column: 11},
+				End:      token.Position{Offset: 38, Line: 3, Column: 11},
+			},
+			"error[P0001]: expected next token to be ;, got EOF\n" +
+				" --> 3:11\n" +
+				"  |\n" +
+				"3 | let z = 3;\n" +
+				"  |           ^\n",
+		},
+		{
+			&Diagnostic{Severity: Error, Code: UnexpectedToken, Message: "no position"},
+			"error[P0001]: no position\n",
+		},
+	}
+
+	for _, tt := range tests {
+		got := tt.diagnostic.Render(src)
+		if got != tt.expected {
+			t.Errorf("Render() got\n%s\nwant\n%s", got, tt.expected)
+		}
+	}
+}
+
+func TestRenderUnicode(t *testing.T) {
+	src := `let größe = "日本" + ;`
+	d := &Diagnostic{
+		Severity: Error,
+		Code:     ExpectedExpression,
+		Message:  "no prefix parse function found for ;",
+		Pos:      token.Position{Offset: 25, Line: 1, Column: 20},
+		End:      token.Position{Offset: 26, Line: 1, Column: 21},
+	}
+	expected := "error[P0002]: no prefix parse function found for ;\n" +
+		" --> 1:20\n" +
+		"  |\n" +
+		"1 | let größe = \"日本\" + ;\n" +
+		"  |                    ^\n"
+	if got := d.Render(src); got != expected {
+		t.Errorf("Render() got\n%s\nwant\n%s", got, expected)
+	}
+
+	// The span of a multibyte token is as wide as its characters.
+	d = &Diagnostic{
+		Severity: Error,
+		Code:     InvalidLiteral,
+		Message:  "something about größe",
+		Pos:      token.Position{Offset: 4, Line: 1, Column: 5},
+		End:      token.Position{Offset: 11, Line: 1, Column: 10},
+	}
+	expected = "error[P0003]: something about größe\n" +
+		" --> 1:5\n" +
+		"  |\n" +
+		"1 | let größe = \"日本\" + ;\n" +
+		"  |     ^^^^^\n"
+	if got := d.Render(src); got != expected {
+		t.Errorf("Render() got\n%s\nwant\n%s", got, expected)
+	}
+}
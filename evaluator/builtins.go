@@ -0,0 +1,1293 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/danielrs/monkey/object"
+)
+
+// Builtins is a set of builtins by name. They are usually functions, but
+// can be any value. Each Evaluator has its own set, so programs can be
+// given different ones.
+type Builtins map[string]object.Object
+
+// DefaultBuiltins returns a new set with the standard builtin functions.
+func DefaultBuiltins() Builtins {
+	b := make(Builtins, len(builtins))
+	for name, fn := range builtins {
+		b[name] = fn
+	}
+	return b
+}
+
+// Register adds v to the set as name. Go functions become builtin
+// functions, which convert their arguments and results as described for
+// FromGo and ToGo and report wrong arguments as runtime errors. Other
+// values are converted with FromGo.
+func (b Builtins) Register(name string, v interface{}) error {
+	var obj object.Object
+	var err error
+
+	if fn := reflect.ValueOf(v); fn.Kind() == reflect.Func && !fn.IsNil() {
+		obj, err = newBuiltin(name, fn)
+	} else {
+		obj, err = FromGo(v)
+	}
+	if err != nil {
+		return err
+	}
+
+	b[name] = obj
+	return nil
+}
+
+var builtins = Builtins{
+	"print": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			vararg := make([]interface{}, 0, len(args))
+			for _, a := range args {
+				vararg = append(vararg, a.Inspect())
+			}
+			fmt.Fprintln(rt.Stdout(), vararg...)
+			return NULL
+		},
+	},
+
+	"len": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch obj := args[0].(type) {
+			case *object.String:
+				// Counts characters, not bytes.
+				return &object.Integer{Value: int64(utf8.RuneCountInString(obj.Value))}
+			case *object.Array:
+				return &object.Integer{Value: int64(len(obj.Elements))}
+			case *object.Range:
+				return &object.Integer{Value: obj.Len()}
+			case *object.Hash:
+				return &object.Integer{Value: int64(len(obj.Pairs))}
+			}
+
+			return newError("argument to `len` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"str": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+			return newString(rt, toStr(args[0]))
+		},
+	},
+
+	"int": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInteger:
+				return arg
+			case *object.Float:
+				// Truncates towards zero.
+				if math.IsNaN(arg.Value) || math.IsInf(arg.Value, 0) {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				i, _ := big.NewFloat(arg.Value).Int(nil)
+				return object.NewInteger(i)
+			case *object.String:
+				i, ok := new(big.Int).SetString(strings.TrimSpace(arg.Value), 10)
+				if !ok {
+					return newError("cannot convert %s to INTEGER", arg.Inspect())
+				}
+				return object.NewInteger(i)
+			}
+
+			return newError("argument to `int` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"float": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInteger:
+				return &object.Float{Value: toFloat(arg)}
+			case *object.Float:
+				return arg
+			case *object.String:
+				f, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("cannot convert %s to FLOAT", arg.Inspect())
+				}
+				return &object.Float{Value: f}
+			}
+
+			return newError("argument to `float` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	// range(stop), range(start, stop) and range(start, stop, step)
+	// return the integers from start, or 0, up to stop.
+	"range": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. want 1 to 3, got %d",
+					len(args))
+			}
+
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				switch n := arg.(type) {
+				case *object.Integer:
+					bounds[i] = n.Value
+				case *object.BigInteger:
+					return newError("range bound out of range: %s", n.Inspect())
+				default:
+					return newError("argument to `range` not supported, got %s",
+						arg.Type())
+				}
+			}
+
+			r := &object.Range{Step: 1}
+			switch len(bounds) {
+			case 1:
+				r.Stop = bounds[0]
+			case 2:
+				r.Start, r.Stop = bounds[0], bounds[1]
+			case 3:
+				r.Start, r.Stop, r.Step = bounds[0], bounds[1], bounds[2]
+			}
+			if r.Step == 0 {
+				return newError("range step cannot be zero")
+			}
+			return r
+		},
+	},
+
+	"head": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch arr := args[0].(type) {
+			case *object.Array:
+				if len(arr.Elements) > 0 {
+					return arr.Elements[0]
+				}
+				return NULL
+			}
+
+			return newError("argument to `head` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"last": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch arr := args[0].(type) {
+			case *object.Array:
+				if len(arr.Elements) > 0 {
+					return arr.Elements[len(arr.Elements)-1]
+				}
+				return NULL
+			}
+
+			return newError("argument to `last` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"tail": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch arr := args[0].(type) {
+			case *object.Array:
+				if len(arr.Elements) > 0 {
+					newElems := make([]object.Object, len(arr.Elements)-1)
+					copy(newElems, arr.Elements[1:])
+					return newArray(rt, newElems)
+				}
+				return &object.Array{Elements: []object.Object{}}
+			}
+
+			return newError("argument to `tail` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"init": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch arr := args[0].(type) {
+			case *object.Array:
+				if len(arr.Elements) > 0 {
+					newElems := make([]object.Object, len(arr.Elements)-1)
+					copy(newElems, arr.Elements[:len(arr.Elements)-1])
+					return newArray(rt, newElems)
+				}
+				return &object.Array{Elements: []object.Object{}}
+			}
+
+			return newError("argument to `init` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"push": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			switch arr := args[0].(type) {
+			case *object.Array:
+				length := len(arr.Elements)
+				newElems := make([]object.Object, length, length+1)
+				copy(newElems, arr.Elements)
+				newElems = append(newElems, args[1])
+				return newArray(rt, newElems)
+			}
+
+			return newError("argument to `push` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	// The array builtins also take ranges, and return new arrays rather
+	// than changing their arguments. Functions passed to them are called
+	// through the runtime, and the first error they return stops them.
+	"map": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			elems, err := elements(rt, "map", args[0])
+			if err != nil {
+				return err
+			}
+
+			newElems := make([]object.Object, len(elems))
+			for i, el := range elems {
+				result := rt.Call(args[1], el)
+				if isError(result) {
+					return result
+				}
+				newElems[i] = result
+			}
+			return newArray(rt, newElems)
+		},
+	},
+
+	"filter": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			elems, err := elements(rt, "filter", args[0])
+			if err != nil {
+				return err
+			}
+
+			newElems := []object.Object{}
+			for _, el := range elems {
+				result := rt.Call(args[1], el)
+				if isError(result) {
+					return result
+				}
+				if isTruthy(result) {
+					newElems = append(newElems, el)
+				}
+			}
+			return newArray(rt, newElems)
+		},
+	},
+
+	// reduce(xs, f) and reduce(xs, f, initial) fold xs from the left,
+	// calling f with the value so far and each element. Without an initial
+	// value the first element is used.
+	"reduce": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 2 || len(args) > 3 {
+				return newError("wrong number of arguments. want 2 to 3, got %d",
+					len(args))
+			}
+
+			elems, err := elements(rt, "reduce", args[0])
+			if err != nil {
+				return err
+			}
+
+			var acc object.Object
+			if len(args) == 3 {
+				acc = args[2]
+			} else if len(elems) > 0 {
+				acc, elems = elems[0], elems[1:]
+			} else {
+				return newError("reduce of empty array with no initial value")
+			}
+
+			for _, el := range elems {
+				acc = rt.Call(args[1], acc, el)
+				if isError(acc) {
+					return acc
+				}
+			}
+			return acc
+		},
+	},
+
+	// sort(xs) sorts with <, and sort(xs, less) with a function telling
+	// whether its first argument goes before the second. Equal elements
+	// keep their order.
+	"sort": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("wrong number of arguments. want 1 to 2, got %d",
+					len(args))
+			}
+
+			elems, err := elements(rt, "sort", args[0])
+			if err != nil {
+				return err
+			}
+
+			newElems := make([]object.Object, len(elems))
+			copy(newElems, elems)
+
+			var failed object.Object
+			sort.SliceStable(newElems, func(i, j int) bool {
+				if failed != nil {
+					return false
+				}
+				var less object.Object
+				if len(args) == 2 {
+					less = rt.Call(args[1], newElems[i], newElems[j])
+				} else {
+					less = evalInfixExpression("<", newElems[i], newElems[j])
+				}
+				if isError(less) {
+					failed = less
+					return false
+				}
+				return isTruthy(less)
+			})
+			if failed != nil {
+				return failed
+			}
+			return newArray(rt, newElems)
+		},
+	},
+
+	// slice(xs, start) and slice(xs, start, end) return the elements from
+	// start up to end, or the end of xs. Negative indexes count from the
+	// end, and indexes out of range stop at either end.
+	"slice": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 2 || len(args) > 3 {
+				return newError("wrong number of arguments. want 2 to 3, got %d",
+					len(args))
+			}
+
+			elems, err := elements(rt, "slice", args[0])
+			if err != nil {
+				return err
+			}
+
+			bounds := []int64{0, int64(len(elems))}
+			for i, arg := range args[1:] {
+				n, ok := arg.(*object.Integer)
+				if !ok {
+					return newError("argument to `slice` not supported, got %s",
+						arg.Type())
+				}
+				bounds[i] = n.Value
+			}
+
+			start, end := sliceBounds(len(elems), bounds[0], bounds[1])
+			newElems := make([]object.Object, end-start)
+			copy(newElems, elems[start:end])
+			return newArray(rt, newElems)
+		},
+	},
+
+	"concat": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			newElems := []object.Object{}
+			for _, arg := range args {
+				elems, err := elements(rt, "concat", arg)
+				if err != nil {
+					return err
+				}
+				newElems = append(newElems, elems...)
+			}
+			return newArray(rt, newElems)
+		},
+	},
+
+	"reverse": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			elems, err := elements(rt, "reverse", args[0])
+			if err != nil {
+				return err
+			}
+
+			newElems := make([]object.Object, len(elems))
+			for i, el := range elems {
+				newElems[len(elems)-1-i] = el
+			}
+			return newArray(rt, newElems)
+		},
+	},
+
+	// contains tells whether an array has an element equal to a value,
+	// or a string has a substring.
+	"contains": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			if _, ok := args[0].(*object.String); ok {
+				strs, err := stringArgs("contains", args)
+				if err != nil {
+					return err
+				}
+				return nativeBooleanToObject(strings.Contains(strs[0], strs[1]))
+			}
+
+			elems, err := elements(rt, "contains", args[0])
+			if err != nil {
+				return err
+			}
+			return nativeBooleanToObject(indexOf(elems, args[1]) >= 0)
+		},
+	},
+
+	// index_of returns the index of the first element equal to a value,
+	// or of the character starting the first occurrence of a substring,
+	// or -1 when there is none.
+	"index_of": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			if _, ok := args[0].(*object.String); ok {
+				strs, err := stringArgs("index_of", args)
+				if err != nil {
+					return err
+				}
+				i := strings.Index(strs[0], strs[1])
+				if i > 0 {
+					i = utf8.RuneCountInString(strs[0][:i])
+				}
+				return &object.Integer{Value: int64(i)}
+			}
+
+			elems, err := elements(rt, "index_of", args[0])
+			if err != nil {
+				return err
+			}
+			return &object.Integer{Value: int64(indexOf(elems, args[1]))}
+		},
+	},
+
+	// zip pairs the elements of two arrays, stopping at the end of the
+	// shorter one.
+	"zip": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			var lists [2][]object.Object
+			for i, arg := range args {
+				elems, err := elements(rt, "zip", arg)
+				if err != nil {
+					return err
+				}
+				lists[i] = elems
+			}
+
+			n := len(lists[0])
+			if len(lists[1]) < n {
+				n = len(lists[1])
+			}
+			newElems := make([]object.Object, n)
+			for i := range newElems {
+				newElems[i] = &object.Array{
+					Elements: []object.Object{lists[0][i], lists[1][i]},
+				}
+			}
+			return newArray(rt, newElems)
+		},
+	},
+
+	// flatten replaces the arrays in an array with their elements. It only
+	// goes one level deep.
+	"flatten": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			elems, err := elements(rt, "flatten", args[0])
+			if err != nil {
+				return err
+			}
+
+			newElems := []object.Object{}
+			for _, el := range elems {
+				if inner, ok := el.(*object.Array); ok {
+					newElems = append(newElems, inner.Elements...)
+				} else {
+					newElems = append(newElems, el)
+				}
+			}
+			return newArray(rt, newElems)
+		},
+	},
+
+	// The string builtins count characters, not bytes, like len does.
+	// split(s) splits s around runs of white space, and split(s, sep)
+	// around each sep, or between characters when sep is empty.
+	"split": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("wrong number of arguments. want 1 to 2, got %d",
+					len(args))
+			}
+
+			strs, err := stringArgs("split", args)
+			if err != nil {
+				return err
+			}
+
+			var parts []string
+			if len(strs) == 1 {
+				parts = strings.Fields(strs[0])
+			} else {
+				parts = strings.Split(strs[0], strs[1])
+			}
+			return stringArray(rt, parts)
+		},
+	},
+
+	// join(xs) and join(xs, sep) concatenate the elements of xs, converted
+	// like str does, putting sep between them.
+	"join": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("wrong number of arguments. want 1 to 2, got %d",
+					len(args))
+			}
+
+			elems, err := elements(rt, "join", args[0])
+			if err != nil {
+				return err
+			}
+			sep := ""
+			if len(args) == 2 {
+				str, ok := args[1].(*object.String)
+				if !ok {
+					return newError("argument to `join` not supported, got %s",
+						args[1].Type())
+				}
+				sep = str.Value
+			}
+
+			parts := make([]string, len(elems))
+			for i, el := range elems {
+				parts[i] = toStr(el)
+			}
+			return newString(rt, strings.Join(parts, sep))
+		},
+	},
+
+	"trim": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			strs, err := stringArgs("trim", args)
+			if err != nil {
+				return err
+			}
+			return newString(rt, strings.TrimSpace(strs[0]))
+		},
+	},
+
+	"upper": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			strs, err := stringArgs("upper", args)
+			if err != nil {
+				return err
+			}
+			return newString(rt, strings.ToUpper(strs[0]))
+		},
+	},
+
+	"lower": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			strs, err := stringArgs("lower", args)
+			if err != nil {
+				return err
+			}
+			return newString(rt, strings.ToLower(strs[0]))
+		},
+	},
+
+	// replace(s, old, new) replaces every old in s with new.
+	"replace": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. want %d, got %d",
+					3, len(args))
+			}
+
+			strs, err := stringArgs("replace", args)
+			if err != nil {
+				return err
+			}
+			// The result is counted before it is made, as it can be much
+			// longer than s.
+			n := int64(strings.Count(strs[0], strs[1]))
+			size := runeCount(strs[0]) + n*(runeCount(strs[2])-runeCount(strs[1]))
+			if err := rt.Alloc(size); err != nil {
+				return err
+			}
+			return &object.String{Value: strings.ReplaceAll(strs[0], strs[1], strs[2])}
+		},
+	},
+
+	"starts_with": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			strs, err := stringArgs("starts_with", args)
+			if err != nil {
+				return err
+			}
+			return nativeBooleanToObject(strings.HasPrefix(strs[0], strs[1]))
+		},
+	},
+
+	"ends_with": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			strs, err := stringArgs("ends_with", args)
+			if err != nil {
+				return err
+			}
+			return nativeBooleanToObject(strings.HasSuffix(strs[0], strs[1]))
+		},
+	},
+
+	// substr(s, start) and substr(s, start, length) return the characters
+	// of s from start, up to length of them. A negative start counts from
+	// the end, and the result stops at the end of s.
+	"substr": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 2 || len(args) > 3 {
+				return newError("wrong number of arguments. want 2 to 3, got %d",
+					len(args))
+			}
+
+			str, ok := args[0].(*object.String)
+			if !ok {
+				return newError("argument to `substr` not supported, got %s",
+					args[0].Type())
+			}
+			nums := []int64{0, math.MaxInt64}
+			for i, arg := range args[1:] {
+				n, ok := arg.(*object.Integer)
+				if !ok {
+					return newError("argument to `substr` not supported, got %s",
+						arg.Type())
+				}
+				nums[i] = n.Value
+			}
+			if nums[1] < 0 {
+				return newError("substr length cannot be negative")
+			}
+
+			runes := []rune(str.Value)
+			start, _ := sliceBounds(len(runes), nums[0], int64(len(runes)))
+			end := len(runes)
+			if nums[1] < int64(end-start) {
+				end = start + int(nums[1])
+			}
+			return newString(rt, string(runes[start:end]))
+		},
+	},
+
+	"repeat": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			str, ok := args[0].(*object.String)
+			if !ok {
+				return newError("argument to `repeat` not supported, got %s",
+					args[0].Type())
+			}
+			n, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError("argument to `repeat` not supported, got %s",
+					args[1].Type())
+			}
+			if n.Value < 0 {
+				return newError("repeat count cannot be negative")
+			}
+			if len(str.Value) > 0 && n.Value > math.MaxInt32/int64(len(str.Value)) {
+				return newError("repeated string too long")
+			}
+			if err := rt.Alloc(runeCount(str.Value) * n.Value); err != nil {
+				return err
+			}
+			return &object.String{Value: strings.Repeat(str.Value, int(n.Value))}
+		},
+	},
+
+	// chars returns the characters of a string as strings.
+	"chars": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			strs, err := stringArgs("chars", args)
+			if err != nil {
+				return err
+			}
+			return stringArray(rt, strings.Split(strs[0], ""))
+		},
+	},
+
+	// format(f, args...) formats its arguments like printf. See
+	// formatString for the verbs.
+	"format": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return newError("wrong number of arguments. want at least 1, got %d",
+					len(args))
+			}
+
+			f, ok := args[0].(*object.String)
+			if !ok {
+				return newError("argument to `format` not supported, got %s",
+					args[0].Type())
+			}
+			return formatString(rt, f.Value, args[1:])
+		},
+	},
+
+	// parse_int(s) and parse_int(s, base) return the integer written in
+	// s, or null when there is none. Unlike int, they only take strings,
+	// and in any base from 2 to 36.
+	"parse_int": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("wrong number of arguments. want 1 to 2, got %d",
+					len(args))
+			}
+
+			str, ok := args[0].(*object.String)
+			if !ok {
+				return newError("argument to `parse_int` not supported, got %s",
+					args[0].Type())
+			}
+			base := int64(10)
+			if len(args) == 2 {
+				n, ok := args[1].(*object.Integer)
+				if !ok {
+					return newError("argument to `parse_int` not supported, got %s",
+						args[1].Type())
+				}
+				if n.Value < 2 || n.Value > 36 {
+					return newError("invalid base %d", n.Value)
+				}
+				base = n.Value
+			}
+
+			i, ok := new(big.Int).SetString(strings.TrimSpace(str.Value), int(base))
+			if !ok {
+				return NULL
+			}
+			return object.NewInteger(i)
+		},
+	},
+
+	// The hash builtins never change their arguments; set, delete and
+	// merge return new hashes. Keys, values and entries come out in key
+	// order, like in for loops.
+	"keys": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch hash := args[0].(type) {
+			case *object.Hash:
+				elems := make([]object.Object, 0, len(hash.Pairs))
+				for _, pair := range sortedPairs(hash) {
+					elems = append(elems, pair.Key)
+				}
+				return newArray(rt, elems)
+			}
+
+			return newError("argument to `keys` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"values": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch hash := args[0].(type) {
+			case *object.Hash:
+				elems := make([]object.Object, 0, len(hash.Pairs))
+				for _, pair := range sortedPairs(hash) {
+					elems = append(elems, pair.Value)
+				}
+				return newArray(rt, elems)
+			}
+
+			return newError("argument to `values` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	// entries returns the pairs of a hash as [key, value] arrays.
+	"entries": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. want %d, got %d",
+					1, len(args))
+			}
+
+			switch hash := args[0].(type) {
+			case *object.Hash:
+				elems := make([]object.Object, 0, len(hash.Pairs))
+				for _, pair := range sortedPairs(hash) {
+					elems = append(elems, &object.Array{
+						Elements: []object.Object{pair.Key, pair.Value},
+					})
+				}
+				return newArray(rt, elems)
+			}
+
+			return newError("argument to `entries` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"has": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			switch hash := args[0].(type) {
+			case *object.Hash:
+				key, err := hashKey(args[1])
+				if err != nil {
+					return err
+				}
+				_, ok := hash.Pairs[key]
+				return nativeBooleanToObject(ok)
+			}
+
+			return newError("argument to `has` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	// get(hash, key) and get(hash, key, default) return the value of key,
+	// or default, or null, when there is none.
+	"get": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) < 2 || len(args) > 3 {
+				return newError("wrong number of arguments. want 2 to 3, got %d",
+					len(args))
+			}
+
+			switch hash := args[0].(type) {
+			case *object.Hash:
+				key, err := hashKey(args[1])
+				if err != nil {
+					return err
+				}
+				if pair, ok := hash.Pairs[key]; ok {
+					return pair.Value
+				}
+				if len(args) == 3 {
+					return args[2]
+				}
+				return NULL
+			}
+
+			return newError("argument to `get` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"set": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 3 {
+				return newError("wrong number of arguments. want %d, got %d",
+					3, len(args))
+			}
+
+			switch hash := args[0].(type) {
+			case *object.Hash:
+				key, err := hashKey(args[1])
+				if err != nil {
+					return err
+				}
+				newHash, err := copyHash(rt, hash, 1)
+				if err != nil {
+					return err
+				}
+				newHash.Pairs[key] = object.HashPair{Key: args[1], Value: args[2]}
+				return newHash
+			}
+
+			return newError("argument to `set` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	"delete": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			switch hash := args[0].(type) {
+			case *object.Hash:
+				key, err := hashKey(args[1])
+				if err != nil {
+					return err
+				}
+				newHash, err := copyHash(rt, hash, 0)
+				if err != nil {
+					return err
+				}
+				delete(newHash.Pairs, key)
+				return newHash
+			}
+
+			return newError("argument to `delete` not supported, got %s",
+				args[0].Type())
+		},
+	},
+
+	// merge returns a hash with the pairs of both hashes. The second one
+	// wins when both have a key.
+	"merge": &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. want %d, got %d",
+					2, len(args))
+			}
+
+			for _, arg := range args {
+				if _, ok := arg.(*object.Hash); !ok {
+					return newError("argument to `merge` not supported, got %s",
+						arg.Type())
+				}
+			}
+
+			first, second := args[0].(*object.Hash), args[1].(*object.Hash)
+			newHash, err := copyHash(rt, first, len(second.Pairs))
+			if err != nil {
+				return err
+			}
+			for key, pair := range second.Pairs {
+				newHash.Pairs[key] = pair
+			}
+			return newHash
+		},
+	},
+}
+
+// stringArgs returns the values of args, which have to be strings.
+func stringArgs(name string, args []object.Object) ([]string, *object.Error) {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := arg.(*object.String)
+		if !ok {
+			return nil, newError("argument to `%s` not supported, got %s",
+				name, arg.Type())
+		}
+		strs[i] = str.Value
+	}
+	return strs, nil
+}
+
+// stringArray returns an array of the given strings, which a builtin
+// made, counting them and their characters against the limits.
+func stringArray(rt object.Runtime, strs []string) object.Object {
+	size := int64(len(strs))
+	for _, s := range strs {
+		size += runeCount(s)
+	}
+	if err := rt.Alloc(size); err != nil {
+		return err
+	}
+
+	elems := make([]object.Object, len(strs))
+	for i, s := range strs {
+		elems[i] = &object.String{Value: s}
+	}
+	return &object.Array{Elements: elems}
+}
+
+// formatString formats args like fmt.Sprintf, with the verbs that make
+// sense for Monkey values: %s for any value, converted like str does, %v
+// for any value as print shows it, %d and %x for integers, %f, %e and %g
+// for numbers, and %% for a percent sign. Flags, width and precision work
+// as in Go.
+func formatString(rt object.Runtime, f string, args []object.Object) object.Object {
+	var out strings.Builder
+	next := 0
+
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			out.WriteByte(f[i])
+			continue
+		}
+
+		// The verb is the first letter after the flags, width and
+		// precision.
+		start := i
+		i++
+		for i < len(f) && strings.IndexByte("+-# 0123456789.", f[i]) >= 0 {
+			i++
+		}
+		if i == len(f) {
+			return newError("format %q ends in an incomplete verb", f)
+		}
+		spec, verb := f[start:i+1], f[i]
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+
+		if next == len(args) {
+			return newError("not enough arguments for format %q, got %d", f, len(args))
+		}
+		arg := args[next]
+		next++
+
+		var value interface{}
+		switch verb {
+		case 's':
+			value = toStr(arg)
+		case 'v':
+			spec = spec[:len(spec)-1] + "s"
+			value = arg.Inspect()
+		case 'd', 'x', 'X':
+			if !isNumber(arg) || arg.Type() == object.FLOAT_OBJ {
+				return newError("format %%%c not supported, got %s", verb, arg.Type())
+			}
+			value = toBig(arg)
+		case 'f', 'e', 'g':
+			if !isNumber(arg) {
+				return newError("format %%%c not supported, got %s", verb, arg.Type())
+			}
+			value = toFloat(arg)
+		default:
+			return newError("unknown format verb %%%c", verb)
+		}
+		fmt.Fprintf(&out, spec, value)
+	}
+
+	if next < len(args) {
+		return newError("too many arguments for format %q. want %d, got %d",
+			f, next, len(args))
+	}
+	return newString(rt, out.String())
+}
+
+// elements returns the elements of an array or range passed to the named
+// builtin, or an error for other values. The elements of a range are
+// made on the spot, so they count against the limits.
+func elements(rt object.Runtime, name string, obj object.Object) ([]object.Object, *object.Error) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		return obj.Elements, nil
+	case *object.Range:
+		if err := rt.Alloc(obj.Len()); err != nil {
+			return nil, err
+		}
+		elems := make([]object.Object, obj.Len())
+		for i := range elems {
+			elems[i] = &object.Integer{Value: obj.At(int64(i))}
+		}
+		return elems, nil
+	}
+	return nil, newError("argument to `%s` not supported, got %s", name, obj.Type())
+}
+
+// newArray returns an array of elems, which a builtin made, counting them
+// against the limits.
+func newArray(rt object.Runtime, elems []object.Object) object.Object {
+	if err := rt.Alloc(int64(len(elems))); err != nil {
+		return err
+	}
+	return &object.Array{Elements: elems}
+}
+
+// newString returns a string a builtin made, counting its characters
+// against the limits.
+func newString(rt object.Runtime, s string) object.Object {
+	if err := rt.Alloc(runeCount(s)); err != nil {
+		return err
+	}
+	return &object.String{Value: s}
+}
+
+// runeCount returns the number of characters in s.
+func runeCount(s string) int64 {
+	return int64(utf8.RuneCountInString(s))
+}
+
+// indexOf returns the index of the first element equal to value, or -1.
+func indexOf(elems []object.Object, value object.Object) int {
+	for i, el := range elems {
+		if equal(el, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sliceBounds returns the indexes of the elements from start up to end in
+// a sequence of the given length. Negative indexes count from the end, and
+// indexes out of range stop at either end.
+func sliceBounds(length int, start, end int64) (int, int) {
+	clamp := func(i int64) int {
+		if i < 0 {
+			i += int64(length)
+		}
+		if i < 0 {
+			return 0
+		}
+		if i > int64(length) {
+			return length
+		}
+		return int(i)
+	}
+
+	lo, hi := clamp(start), clamp(end)
+	if hi < lo {
+		hi = lo
+	}
+	return lo, hi
+}
+
+// hashKey returns the key of obj in a hash, or an error when obj can't be
+// one.
+func hashKey(obj object.Object) (object.HashKey, *object.Error) {
+	key, ok := object.HashKeyOf(obj)
+	if !ok {
+		return object.HashKey{}, newError("unusable as hash key: %s", obj.Type())
+	}
+	return key, nil
+}
+
+// copyHash returns a copy of hash with room for extra pairs, counting them
+// all against the limits.
+func copyHash(rt object.Runtime, hash *object.Hash, extra int) (*object.Hash, *object.Error) {
+	if err := rt.Alloc(int64(len(hash.Pairs) + extra)); err != nil {
+		return nil, err
+	}
+	pairs := make(map[object.HashKey]object.HashPair, len(hash.Pairs)+extra)
+	for key, pair := range hash.Pairs {
+		pairs[key] = pair
+	}
+	return &object.Hash{Pairs: pairs}, nil
+}
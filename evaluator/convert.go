@@ -0,0 +1,591 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+
+	"github.com/danielrs/monkey/object"
+)
+
+// Conversions between Go values and Monkey objects, used to expose Go
+// functions as builtins.
+//
+// Going from Go to Monkey, integers of any size, including *big.Int,
+// become INTEGER, floats become FLOAT, strings become STRING, booleans
+// become BOOLEAN and nil becomes nil. Slices and arrays become ARRAY_OBJ.
+// Maps and structs become HASH_OBJ; the keys of a struct are the names of
+// its exported fields, or the name given in a `monkey:"name"` tag, and the
+// fields of embedded structs are keys of it too. Pointers are followed,
+// functions become builtins and errors become runtime errors. Objects are
+// kept as they are. Values that contain themselves can't be converted.
+//
+// Going from Monkey to Go does the opposite, checking the object fits in
+// the wanted type.
+
+var (
+	objectType  = reflect.TypeOf((*object.Object)(nil)).Elem()
+	runtimeType = reflect.TypeOf((*object.Runtime)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType  = reflect.TypeOf((*big.Int)(nil))
+)
+
+// FromGo converts a Go value to a Monkey object.
+func FromGo(v interface{}) (object.Object, error) {
+	if v == nil {
+		return NULL, nil
+	}
+	return fromValue(reflect.ValueOf(v), make(map[visit]bool))
+}
+
+// visit is a pointer, map or slice being converted. Meeting it again while
+// converting it means it contains itself.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+func fromValue(v reflect.Value, seen map[visit]bool) (object.Object, error) {
+	if v.Type().Implements(objectType) && v.Kind() != reflect.Interface {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return NULL, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+	if v.Type() == bigIntType {
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return object.NewInteger(new(big.Int).Set(v.Interface().(*big.Int))), nil
+	}
+	if v.Type().Implements(errorType) && v.Kind() != reflect.Interface {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return NULL, nil
+		}
+		return newError("%s", v.Interface().(error).Error()), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		key := visit{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			key.len = v.Len()
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("cannot convert %s that contains itself", v.Type())
+		}
+		seen[key] = true
+		defer delete(seen, key)
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return nativeBooleanToObject(v.Bool()), nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return object.NewInteger(new(big.Int).SetUint64(v.Uint())), nil
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return NULL, nil
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			el, err := fromValue(v.Index(i), seen)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = el
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		pairs := make(map[object.HashKey]object.HashPair, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := fromValue(iter.Key(), seen)
+			if err != nil {
+				return nil, err
+			}
+			hashed, ok := object.HashKeyOf(key)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := fromValue(iter.Value(), seen)
+			if err != nil {
+				return nil, err
+			}
+			pairs[hashed] = object.HashPair{Key: key, Value: value}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+
+	case reflect.Struct:
+		pairs := make(map[object.HashKey]object.HashPair)
+		for _, f := range structFields(v.Type()) {
+			fv, ok := fieldOf(v, f.index)
+			if !ok {
+				continue
+			}
+			value, err := fromValue(fv, seen)
+			if err != nil {
+				return nil, err
+			}
+			key := &object.String{Value: f.name}
+			pairs[key.HashKey()] = object.HashPair{Key: key, Value: value}
+		}
+		return &object.Hash{Pairs: pairs}, nil
+
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		return fromValue(v.Elem(), seen)
+
+	case reflect.Func:
+		if v.IsNil() {
+			return NULL, nil
+		}
+		builtin, err := newBuiltin("fn", v)
+		if err != nil {
+			return nil, err
+		}
+		return builtin, nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a Monkey object", v.Type())
+}
+
+// ToGo converts obj to a value of type t.
+func ToGo(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if obj == nil {
+		obj = NULL
+	}
+
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		v, err := toInterface(obj)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(v), nil
+	}
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("want %s, got %s", monkeyType(t), obj.Type())
+	}
+
+	if b, ok := obj.(*object.BigInteger); ok {
+		if t == bigIntType {
+			return reflect.ValueOf(new(big.Int).Set(b.Value)), nil
+		}
+		if k := t.Kind(); k >= reflect.Int && k <= reflect.Uintptr {
+			// Only unsigned types have room for integers above int64.
+			v := reflect.New(t).Elem()
+			if k < reflect.Uint || !b.Value.IsUint64() || v.OverflowUint(b.Value.Uint64()) {
+				return reflect.Value{}, fmt.Errorf("%s overflows %s", b.Inspect(), t)
+			}
+			v.SetUint(b.Value.Uint64())
+			return v, nil
+		}
+	} else if i, ok := obj.(*object.Integer); ok && t == bigIntType {
+		return reflect.ValueOf(big.NewInt(i.Value)), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, ok := obj.(*object.Boolean); ok {
+			return reflect.ValueOf(b.Value).Convert(t), nil
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := obj.(*object.Integer); ok {
+			v := reflect.New(t).Elem()
+			if v.OverflowInt(i.Value) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetInt(i.Value)
+			return v, nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := obj.(*object.Integer); ok {
+			v := reflect.New(t).Elem()
+			if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+				return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, t)
+			}
+			v.SetUint(uint64(i.Value))
+			return v, nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		// Integers are fine where floats are wanted, as in arithmetic.
+		if isNumber(obj) {
+			return reflect.ValueOf(toFloat(obj)).Convert(t), nil
+		}
+
+	case reflect.String:
+		if s, ok := obj.(*object.String); ok {
+			return reflect.ValueOf(s.Value).Convert(t), nil
+		}
+
+	case reflect.Slice:
+		if _, ok := obj.(*object.Nil); ok {
+			return reflect.Zero(t), nil
+		}
+		if arr, ok := obj.(*object.Array); ok {
+			v := reflect.MakeSlice(t, len(arr.Elements), len(arr.Elements))
+			for i, el := range arr.Elements {
+				ev, err := ToGo(el, t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				v.Index(i).Set(ev)
+			}
+			return v, nil
+		}
+
+	case reflect.Map:
+		if _, ok := obj.(*object.Nil); ok {
+			return reflect.Zero(t), nil
+		}
+		if hash, ok := obj.(*object.Hash); ok {
+			v := reflect.MakeMapWithSize(t, len(hash.Pairs))
+			for _, pair := range hash.Pairs {
+				kv, err := ToGo(pair.Key, t.Key())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				vv, err := ToGo(pair.Value, t.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				v.SetMapIndex(kv, vv)
+			}
+			return v, nil
+		}
+
+	case reflect.Struct:
+		if hash, ok := obj.(*object.Hash); ok {
+			v := reflect.New(t).Elem()
+			for _, f := range structFields(t) {
+				key := &object.String{Value: f.name}
+				pair, ok := hash.Pairs[key.HashKey()]
+				if !ok {
+					continue
+				}
+				fv, err := ToGo(pair.Value, t.FieldByIndex(f.index).Type)
+				if err != nil {
+					return reflect.Value{}, fmt.Errorf("field %s: %s", f.name, err)
+				}
+				if err := setField(v, f.index, fv); err != nil {
+					return reflect.Value{}, fmt.Errorf("field %s: %s", f.name, err)
+				}
+			}
+			return v, nil
+		}
+
+	case reflect.Ptr:
+		if _, ok := obj.(*object.Nil); ok {
+			return reflect.Zero(t), nil
+		}
+		ev, err := ToGo(obj, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.Elem())
+		v.Elem().Set(ev)
+		return v, nil
+	}
+
+	return mismatch()
+}
+
+// toInterface converts obj to the natural Go value for it. Arrays become
+// []interface{} and hashes map[string]interface{} when all of their keys
+// are strings, or map[interface{}]interface{} otherwise. Objects without
+// a Go equivalent, like functions, are kept as they are, and so are
+// arrays and hashes used as keys, as Go can't use slices and maps as keys.
+func toInterface(obj object.Object) (interface{}, error) {
+	switch obj := obj.(type) {
+	case *object.Nil:
+		return nil, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.BigInteger:
+		return new(big.Int).Set(obj.Value), nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+
+	case *object.Array:
+		s := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			v, err := toInterface(el)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = v
+		}
+		return s, nil
+
+	case *object.Hash:
+		byString := make(map[string]interface{}, len(obj.Pairs))
+		byAny := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			var k interface{} = pair.Key
+			switch pair.Key.(type) {
+			case *object.Array, *object.Hash:
+			default:
+				var err error
+				k, err = toInterface(pair.Key)
+				if err != nil {
+					return nil, err
+				}
+			}
+			v, err := toInterface(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := k.(string); ok && byString != nil {
+				byString[s] = v
+			} else {
+				byString = nil
+			}
+			byAny[k] = v
+		}
+		if byString != nil {
+			return byString, nil
+		}
+		return byAny, nil
+	}
+
+	return obj, nil
+}
+
+// monkeyType describes the objects that convert to values of type t.
+func monkeyType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return object.BOOLEAN_OBJ
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return object.INTEGER_OBJ
+	case reflect.Float32, reflect.Float64:
+		return object.FLOAT_OBJ
+	case reflect.String:
+		return object.STRING_OBJ
+	case reflect.Slice:
+		return object.ARRAY_OBJ
+	case reflect.Map, reflect.Struct:
+		return object.HASH_OBJ
+	case reflect.Ptr:
+		return monkeyType(t.Elem())
+	}
+	return t.String()
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields returns the exported fields of a struct with the keys
+// they have in hashes, sorted by key. The fields of embedded structs
+// without a tag are included; as in Go, fields hide the ones with the same
+// key deeper in embedded structs, and keys found more than once at the
+// same depth are left out.
+func structFields(t reflect.Type) []structField {
+	var all []structField
+	embeddedFields(t, nil, &all, make(map[reflect.Type]bool))
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].name != all[j].name {
+			return all[i].name < all[j].name
+		}
+		return len(all[i].index) < len(all[j].index)
+	})
+
+	var fields []structField
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].name == all[i].name {
+			j++
+		}
+		if j == i+1 || len(all[i+1].index) > len(all[i].index) {
+			fields = append(fields, all[i])
+		}
+		i = j
+	}
+	return fields
+}
+
+// embeddedFields adds the fields of the struct type t, found at index, to
+// fields, following embedded structs. Structs already being followed are
+// skipped, as embedded pointers can lead back to them.
+func embeddedFields(t reflect.Type, index []int, fields *[]structField, seen map[reflect.Type]bool) {
+	if seen[t] {
+		return
+	}
+	seen[t] = true
+	defer delete(seen, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("monkey")
+		if tag == "-" {
+			continue
+		}
+		fieldIndex := append(index[:len(index):len(index)], i)
+
+		if f.Anonymous && tag == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embeddedFields(ft, fieldIndex, fields, seen)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := f.Name
+		if tag != "" {
+			name = tag
+		}
+		*fields = append(*fields, structField{name: name, index: fieldIndex})
+	}
+}
+
+// fieldOf returns the field of the struct v at index, or false when it is
+// in an embedded struct behind a nil pointer.
+func fieldOf(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// setField sets the field of the struct v at index to fv, making the
+// embedded structs on the way that are nil pointers.
+func setField(v reflect.Value, index []int, fv reflect.Value) error {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return fmt.Errorf("cannot set embedded pointer to unexported %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	v.Set(fv)
+	return nil
+}
+
+// newBuiltin wraps the Go function fn in a builtin. Its parameters can
+// start with an object.Runtime, which is passed the runtime calling it,
+// and it can be variadic. It can return nothing, a value, an error or a
+// value and an error; non-nil errors become runtime errors.
+func newBuiltin(name string, fn reflect.Value) (*object.Builtin, error) {
+	t := fn.Type()
+
+	numOut := t.NumOut()
+	if numOut > 2 || (numOut == 2 && t.Out(1) != errorType) {
+		return nil, fmt.Errorf("builtin %s must return at most a value and an error", name)
+	}
+
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == runtimeType {
+		first = 1
+	}
+	numParams := t.NumIn() - first
+
+	return &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			if t.IsVariadic() {
+				if len(args) < numParams-1 {
+					return newError("wrong number of arguments. want at least %d, got %d",
+						numParams-1, len(args))
+				}
+			} else if len(args) != numParams {
+				return newError("wrong number of arguments. want %d, got %d",
+					numParams, len(args))
+			}
+
+			in := make([]reflect.Value, 0, first+len(args))
+			if first == 1 {
+				in = append(in, reflect.ValueOf(&rt).Elem())
+			}
+			for i, arg := range args {
+				var pt reflect.Type
+				if t.IsVariadic() && first+i >= t.NumIn()-1 {
+					pt = t.In(t.NumIn() - 1).Elem()
+				} else {
+					pt = t.In(first + i)
+				}
+				v, err := ToGo(arg, pt)
+				if err != nil {
+					return newError("argument %d to `%s` not supported: %s", i+1, name, err)
+				}
+				in = append(in, v)
+			}
+
+			out := fn.Call(in)
+
+			if numOut > 0 && t.Out(numOut-1) == errorType {
+				if err := out[numOut-1]; !err.IsNil() {
+					return newError("%s", err.Interface().(error).Error())
+				}
+				out = out[:numOut-1]
+			}
+			if len(out) == 0 {
+				return NULL
+			}
+
+			result, err := fromValue(out[0], make(map[visit]bool))
+			if err != nil {
+				return newError("result of `%s` not supported: %s", name, err)
+			}
+			return result
+		},
+	}, nil
+}
@@ -1,10 +1,19 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/danielrs/monkey/ast"
 	"github.com/danielrs/monkey/object"
+	"github.com/danielrs/monkey/token"
 )
 
 var (
@@ -13,167 +22,238 @@ var (
 	FALSE = &object.Boolean{Value: false}
 )
 
-var builtins = map[string]*object.Builtin{
-	"print": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			vararg := make([]interface{}, 0, len(args))
-			for _, a := range args {
-				vararg = append(vararg, a.Inspect())
-			}
-			fmt.Println(vararg...)
-			return NULL
-		},
-	},
-
-	"len": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. want %d, got %d",
-					1, len(args))
-			}
+// Evaluator walks the AST evaluating every node. It keeps track of the
+// Monkey functions being called so runtime errors can report where they
+// happened and through which calls.
+type Evaluator struct {
+	limits   Limits
+	builtins Builtins
+	stack    []frame
+	strict   bool
+
+	// builtinCall is where the builtin being run was called, which is
+	// where the functions it calls back are called from.
+	builtinCall token.Position
+
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	// State of the current evaluation, for enforcing the limits.
+	ctx    context.Context
+	steps  int64
+	allocs int64
+}
 
-			switch obj := args[0].(type) {
-			case *object.String:
-				return &object.Integer{Value: int64(len(obj.Value))}
-			case *object.Array:
-				return &object.Integer{Value: int64(len(obj.Elements))}
-			}
+// frame is a call to a Monkey function.
+type frame struct {
+	function string         // name of the called function
+	call     token.Position // where it was called from
+}
 
-			return newError("argument to `len` not supported, got %s",
-				args[0].Type())
-		},
-	},
+// DefaultMaxDepth is the call depth allowed when no other is given. Deeper
+// recursion would risk exhausting the Go stack.
+const DefaultMaxDepth = 10000
+
+// Limits bounds the resources a single evaluation can use. Zero fields
+// mean no limit, except for MaxDepth, which defaults to DefaultMaxDepth.
+// Builtins and operators count each element of the arrays and character
+// of the strings they make as an object created, and each word of the big
+// integers, as they can make large ones at once.
+type Limits struct {
+	MaxSteps  int64         // nodes evaluated and functions called
+	MaxDepth  int           // nested calls to Monkey functions
+	MaxAllocs int64         // objects created
+	Timeout   time.Duration // wall-clock time
+}
 
-	"head": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. want %d, got %d",
-					1, len(args))
-			}
+// checkInterval is how many steps are taken between checks of the
+// context, which are more expensive than the other checks.
+const checkInterval = 1024
 
-			switch arr := args[0].(type) {
-			case *object.Array:
-				if len(arr.Elements) > 0 {
-					return arr.Elements[0]
-				}
-				return NULL
-			}
+// Returns a pointer to a new Evaluator.
+func New() *Evaluator {
+	return NewWithLimits(Limits{})
+}
 
-			return newError("argument to `head` not supported, got %s",
-				args[0].Type())
-		},
-	},
+// NewWithLimits returns an Evaluator that stops evaluations exceeding the
+// given limits with an error of the matching kind.
+func NewWithLimits(limits Limits) *Evaluator {
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+	return &Evaluator{
+		limits:   limits,
+		builtins: builtins,
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+		stderr:   os.Stderr,
+	}
+}
 
-	"last": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. want %d, got %d",
-					1, len(args))
-			}
+// SetBuiltins sets the builtin functions available to programs.
+func (e *Evaluator) SetBuiltins(b Builtins) {
+	e.builtins = b
+}
 
-			switch arr := args[0].(type) {
-			case *object.Array:
-				if len(arr.Elements) > 0 {
-					return arr.Elements[len(arr.Elements)-1]
-				}
-				return NULL
-			}
+// SetStrict sets whether indexes and slice bounds out of range are errors,
+// rather than giving null or stopping at the ends.
+func (e *Evaluator) SetStrict(strict bool) {
+	e.strict = strict
+}
 
-			return newError("argument to `last` not supported, got %s",
-				args[0].Type())
-		},
-	},
+// SetStreams sets the standard streams used by builtins. Nil arguments
+// leave the stream as it was, which is initially the one of the process.
+func (e *Evaluator) SetStreams(stdin io.Reader, stdout, stderr io.Writer) {
+	if stdin != nil {
+		e.stdin = stdin
+	}
+	if stdout != nil {
+		e.stdout = stdout
+	}
+	if stderr != nil {
+		e.stderr = stderr
+	}
+}
 
-	"tail": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. want %d, got %d",
-					1, len(args))
-			}
+// The evaluator is the runtime of the builtins it calls.
 
-			switch arr := args[0].(type) {
-			case *object.Array:
-				if len(arr.Elements) > 0 {
-					newElems := make([]object.Object, len(arr.Elements)-1)
-					copy(newElems, arr.Elements[1:])
-					return &object.Array{newElems}
-				}
-				return &object.Array{[]object.Object{}}
-			}
+func (e *Evaluator) Stdin() io.Reader  { return e.stdin }
+func (e *Evaluator) Stdout() io.Writer { return e.stdout }
+func (e *Evaluator) Stderr() io.Writer { return e.stderr }
 
-			return newError("argument to `tail` not supported, got %s",
-				args[0].Type())
-		},
-	},
+func (e *Evaluator) Call(fn object.Object, args ...object.Object) object.Object {
+	return e.applyFunction(e.builtinCall, fn, args)
+}
 
-	"init": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. want %d, got %d",
-					1, len(args))
-			}
+func (e *Evaluator) Alloc(n int64) *object.Error {
+	e.allocs += n
+	if e.limits.MaxAllocs > 0 && e.allocs > e.limits.MaxAllocs {
+		return newLimitError(object.AllocLimitError,
+			"allocation limit exceeded: %d", e.limits.MaxAllocs)
+	}
+	return nil
+}
 
-			switch arr := args[0].(type) {
-			case *object.Array:
-				if len(arr.Elements) > 0 {
-					newElems := make([]object.Object, len(arr.Elements)-1)
-					copy(newElems, arr.Elements[:len(arr.Elements)-1])
-					return &object.Array{newElems}
-				}
-				return &object.Array{[]object.Object{}}
-			}
+// Eval evaluates the given node with a new Evaluator.
+func Eval(env *object.Environment, node ast.Node) object.Object {
+	return New().Eval(env, node)
+}
 
-			return newError("argument to `init` not supported, got %s",
-				args[0].Type())
-		},
-	},
+// Eval evaluates the given node in env. Errors coming out of it are tagged
+// with the position of the innermost node that failed and the call stack
+// at that point.
+func (e *Evaluator) Eval(env *object.Environment, node ast.Node) object.Object {
+	return e.EvalContext(context.Background(), env, node)
+}
 
-	"push": &object.Builtin{
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 2 {
-				return newError("wrong number of arguments. want %d, got %d",
-					2, len(args))
-			}
+// EvalContext is like Eval, but stops when ctx is done. Errors caused by
+// ctx or by the limits of the evaluator have a Kind other than
+// object.RuntimeError.
+func (e *Evaluator) EvalContext(ctx context.Context, env *object.Environment, node ast.Node) object.Object {
+	defer e.start(ctx)()
+	return e.evalNode(env, node)
+}
 
-			switch arr := args[0].(type) {
-			case *object.Array:
-				length := len(arr.Elements)
-				newElems := make([]object.Object, length, length+1)
-				copy(newElems, arr.Elements)
-				newElems = append(newElems, args[1])
-				return &object.Array{newElems}
-			}
+// CallContext calls fn, which should be a function, with the given
+// arguments; nil arguments are passed as NULL. Like EvalContext, it stops
+// when ctx is done or a limit is exceeded.
+func (e *Evaluator) CallContext(ctx context.Context, fn object.Object, args ...object.Object) object.Object {
+	defer e.start(ctx)()
+
+	if fn == nil {
+		return newError("not a function: %s", object.NIL_OBJ)
+	}
+	args = append([]object.Object(nil), args...)
+	for i, arg := range args {
+		if arg == nil {
+			args[i] = NULL
+		}
+	}
 
-			return newError("argument to `push` not supported, got %s",
-				args[0].Type())
-		},
-	},
+	result := e.applyFunction(token.Position{}, fn, args)
+	if err, ok := result.(*object.Error); ok && err.Stack == nil {
+		err.Stack = e.trace(err.Pos)
+	}
+	return result
 }
 
-func Eval(env *object.Environment, node ast.Node) object.Object {
+// start resets the state of the evaluator for a new evaluation, returning
+// a function that releases its resources.
+func (e *Evaluator) start(ctx context.Context) context.CancelFunc {
+	cancel := context.CancelFunc(func() {})
+	if e.limits.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, e.limits.Timeout)
+	}
+
+	e.ctx = ctx
+	e.steps = 0
+	e.allocs = 0
+	e.stack = e.stack[:0]
+
+	return cancel
+}
+
+// evalNode evaluates a node, tagging the errors coming out of it.
+func (e *Evaluator) evalNode(env *object.Environment, node ast.Node) object.Object {
+	var result object.Object
+	if err := e.step(); err != nil {
+		result = err
+	} else {
+		result = e.eval(env, node)
+	}
+	if err, ok := result.(*object.Error); ok && err.Stack == nil {
+		err.Pos = node.Pos()
+		err.Stack = e.trace(err.Pos)
+	}
+	return result
+}
+
+func (e *Evaluator) eval(env *object.Environment, node ast.Node) object.Object {
 	switch node := node.(type) {
 	// Statements.
 	case *ast.Program:
-		return evalProgram(env, node)
+		return e.evalProgram(env, node)
 
 	case *ast.ExpressionStatement:
-		return Eval(env, node.Expression)
+		return e.evalNode(env, node.Expression)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(env, node)
+		return e.evalBlockStatement(env, node)
 
 	case *ast.ReturnStatement:
-		return try(Eval(env, node.Value), func(val object.Object) object.Object {
-			return &object.ReturnValue{val}
+		return try(e.evalNode(env, node.Value), func(val object.Object) object.Object {
+			return &object.ReturnValue{Value: val}
 		})
 
 	case *ast.LetStatement:
-		return try(Eval(env, node.Value), func(val object.Object) object.Object {
-			env.Set(node.Identifier.Value, val)
-			return &object.Nil{}
+		return try(e.evalNode(env, node.Value), func(val object.Object) object.Object {
+			if node.IsConst() {
+				env.SetConst(node.Identifier.Value, val)
+			} else {
+				env.Set(node.Identifier.Value, val)
+			}
+			return NULL
 		})
 
+	case *ast.WhileStatement:
+		return e.evalWhileStatement(env, node)
+
+	case *ast.ForStatement:
+		return e.evalForStatement(env, node)
+
+	case *ast.BranchStatement:
+		if node.IsBreak() {
+			return breakLoop
+		}
+		return continueLoop
+
+	case *ast.BadStatement:
+		return newError("cannot evaluate statement with syntax errors")
+
 	// Expressions.
+	case *ast.BadExpression:
+		return newError("cannot evaluate expression with syntax errors")
+
 	case *ast.BooleanLiteral:
 		if node.Value {
 			return TRUE
@@ -181,74 +261,104 @@ func Eval(env *object.Environment, node ast.Node) object.Object {
 		return FALSE
 
 	case *ast.IntegerLiteral:
-		return &object.Integer{node.Value}
+		if node.Big != nil {
+			return e.alloc(&object.BigInteger{Value: node.Big})
+		}
+		return e.alloc(&object.Integer{Value: node.Value})
+
+	case *ast.FloatLiteral:
+		return e.alloc(&object.Float{Value: node.Value})
 
 	case *ast.StringLiteral:
-		return &object.String{node.Value}
+		return e.alloc(&object.String{Value: node.Value})
+
+	case *ast.InterpolatedString:
+		parts := e.evalExpressions(env, node.Parts)
+		if len(parts) >= 1 && isError(parts[0]) {
+			return parts[0]
+		}
+		return e.allocSized(interpolate(parts))
 
 	case *ast.Identifier:
-		return evalIdentifier(env, node)
+		return e.evalIdentifier(env, node)
 
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Body: body, Env: env}
+		return e.alloc(&object.Function{Name: node.Name, Parameters: params, Body: body, Env: env})
 
 	case *ast.ArrayLiteral:
-		elems := evalExpressions(env, node.Elements)
+		elems := e.evalExpressions(env, node.Elements)
 		if len(elems) >= 1 && isError(elems[0]) {
 			return elems[0]
 		}
-		return &object.Array{elems}
+		return e.alloc(&object.Array{Elements: elems})
 
 	case *ast.IndexExpression:
-		return try(Eval(env, node.Left), func(l object.Object) object.Object {
-			return try(Eval(env, node.Index), func(i object.Object) object.Object {
-				return evalIndexExpression(l, i)
+		return try(e.evalNode(env, node.Left), func(l object.Object) object.Object {
+			return try(e.evalNode(env, node.Index), func(i object.Object) object.Object {
+				return evalIndexExpression(l, i, e.strict)
+			})
+		})
+
+	case *ast.SliceExpression:
+		return try(e.evalNode(env, node.Left), func(l object.Object) object.Object {
+			return try(e.evalBound(env, node.Low), func(low object.Object) object.Object {
+				return try(e.evalBound(env, node.High), func(high object.Object) object.Object {
+					return e.allocSized(evalSliceExpression(l, low, high, e.strict))
+				})
 			})
 		})
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(env, node)
+		return e.alloc(e.evalHashLiteral(env, node))
 
 	case *ast.PrefixExpression:
-		return try(Eval(env, node.Right), func(right object.Object) object.Object {
-			return evalPrefixExpression(node.Operator, right)
+		return try(e.evalNode(env, node.Right), func(right object.Object) object.Object {
+			return e.allocSized(evalPrefixExpression(node.Operator, right))
 		})
 
 	case *ast.InfixExpression:
 		// Checks for logical expression first.
 		if node.Operator == "&&" || node.Operator == "||" {
-			return evalLazyInfixExpression(env, node)
+			return e.evalLazyInfixExpression(env, node)
 		}
 
 		// Otherwise does normal infix expression.
-		return try(Eval(env, node.Left), func(l object.Object) object.Object {
-			return try(Eval(env, node.Right), func(r object.Object) object.Object {
-				return evalInfixExpression(node.Operator, l, r)
+		return try(e.evalNode(env, node.Left), func(l object.Object) object.Object {
+			return try(e.evalNode(env, node.Right), func(r object.Object) object.Object {
+				return e.allocSized(evalInfixExpression(node.Operator, l, r))
 			})
 		})
 
+	case *ast.AssignExpression:
+		return e.evalAssignExpression(env, node)
+
 	case *ast.IfExpression:
-		return evalIfExpression(env, node)
+		return e.evalIfExpression(env, node)
+
+	case *ast.MatchExpression:
+		return e.evalMatchExpression(env, node, func(env *object.Environment, body ast.Expression) object.Object {
+			return e.evalNode(env, body)
+		})
 
 	case *ast.CallExpression:
-		return try(Eval(env, node.Function), func(f object.Object) object.Object {
-			args := evalExpressions(env, node.Arguments)
+		return try(e.evalNode(env, node.Function), func(f object.Object) object.Object {
+			args := e.evalExpressions(env, node.Arguments)
 			if len(args) >= 1 && isError(args[0]) {
 				return args[0]
 			}
-			return applyFunction(f, args)
+			return e.applyFunction(node.Pos(), f, args)
 		})
 	}
 
-	return nil
+	return newError("cannot evaluate %T", node)
 }
 
-func evalProgram(env *object.Environment, program *ast.Program) object.Object {
+func (e *Evaluator) evalProgram(env *object.Environment, program *ast.Program) object.Object {
 	var result object.Object
 	for _, s := range program.Statements {
-		result = Eval(env, s)
+		result = e.evalNode(env, s)
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
@@ -259,37 +369,51 @@ func evalProgram(env *object.Environment, program *ast.Program) object.Object {
 	return result
 }
 
-func evalBlockStatement(env *object.Environment, block *ast.BlockStatement) object.Object {
-	var result object.Object
+func (e *Evaluator) evalBlockStatement(env *object.Environment, block *ast.BlockStatement) object.Object {
+	// Empty blocks are nil, like missing else branches.
+	var result object.Object = NULL
 	for _, s := range block.Statements {
-		result = Eval(env, s)
+		result = e.evalNode(env, s)
 		if result != nil {
 			if result.Type() == object.RETURN_VALUE_OBJ ||
 				result.Type() == object.ERROR_OBJ {
 				return result
 			}
+			if _, ok := result.(*loopControl); ok {
+				return result
+			}
 		}
 	}
 	return result
 }
 
-func evalIndexExpression(left object.Object, index object.Object) object.Object {
+// evalIndexExpression indexes arrays and strings, where negative indexes
+// count from the end, and hashes. Indexes out of range give null, or an
+// error in strict mode.
+func evalIndexExpression(left object.Object, index object.Object, strict bool) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		arr := left.(*object.Array)
-		idx := index.(*object.Integer).Value
-		max := int64(len(arr.Elements) - 1)
-		if idx < 0 || idx > max {
-			return NULL
+		i, ok := sequenceIndex(index, len(arr.Elements))
+		if !ok {
+			return outOfRange(index, len(arr.Elements), strict)
+		}
+		return arr.Elements[i]
+	case left.Type() == object.STRING_OBJ && index.Type() == object.INTEGER_OBJ:
+		// Strings are indexed by character, not by byte.
+		runes := []rune(left.(*object.String).Value)
+		i, ok := sequenceIndex(index, len(runes))
+		if !ok {
+			return outOfRange(index, len(runes), strict)
 		}
-		return arr.Elements[idx]
+		return &object.String{Value: string(runes[i])}
 	case left.Type() == object.HASH_OBJ:
 		hash := left.(*object.Hash)
-		key, ok := index.(object.Hasher)
+		key, ok := object.HashKeyOf(index)
 		if !ok {
 			return newError("unusable as hash key: %s", index.Type())
 		}
-		pair, ok := hash.Pairs[key.HashKey()]
+		pair, ok := hash.Pairs[key]
 		if !ok {
 			return NULL
 		}
@@ -299,25 +423,117 @@ func evalIndexExpression(left object.Object, index object.Object) object.Object
 	return newError("index operator not supported: %s", left.Type())
 }
 
-func evalHashLiteral(env *object.Environment, node *ast.HashLiteral) object.Object {
+// sequenceIndex returns the position of index in a sequence of the given
+// length, counting from the end for negative indexes. Big integers are
+// always out of range.
+func sequenceIndex(index object.Object, length int) (int, bool) {
+	n, ok := index.(*object.Integer)
+	if !ok {
+		return 0, false
+	}
+	i := n.Value
+	if i < 0 {
+		i += int64(length)
+	}
+	if i < 0 || i >= int64(length) {
+		return 0, false
+	}
+	return int(i), true
+}
+
+func outOfRange(index object.Object, length int, strict bool) object.Object {
+	if strict {
+		return newError("index out of range: %s with length %d", index.Inspect(), length)
+	}
+	return NULL
+}
+
+// evalBound evaluates a bound of a slice, which is null when left out.
+func (e *Evaluator) evalBound(env *object.Environment, bound ast.Expression) object.Object {
+	if bound == nil {
+		return NULL
+	}
+	return e.evalNode(env, bound)
+}
+
+// evalSliceExpression slices arrays and strings. Bounds are integers, or
+// null when left out, and negative ones count from the end. Out of range
+// bounds stop at either end, or are an error in strict mode.
+func evalSliceExpression(left, low, high object.Object, strict bool) object.Object {
+	var length int
+	var runes []rune
+	switch left := left.(type) {
+	case *object.Array:
+		length = len(left.Elements)
+	case *object.String:
+		runes = []rune(left.Value)
+		length = len(runes)
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+
+	bounds := []int64{0, int64(length)}
+	for i, bound := range []object.Object{low, high} {
+		switch n := bound.(type) {
+		case *object.Integer:
+			bounds[i] = n.Value
+		case *object.BigInteger:
+			bounds[i] = math.MaxInt64
+			if n.Value.Sign() < 0 {
+				bounds[i] = math.MinInt64
+			}
+		case *object.Nil:
+		default:
+			return newError("slice bound must be an integer, got %s", bound.Type())
+		}
+	}
+
+	lo, hi := sliceBounds(length, bounds[0], bounds[1])
+	if strict {
+		for i, n := range bounds {
+			if n < 0 {
+				bounds[i] += int64(length)
+			}
+		}
+		if bounds[0] < 0 || bounds[1] > int64(length) || bounds[0] > bounds[1] {
+			return newError("slice bounds out of range: [%s:%s] with length %d",
+				boundString(low), boundString(high), length)
+		}
+	}
+
+	if runes != nil {
+		return &object.String{Value: string(runes[lo:hi])}
+	}
+	elems := make([]object.Object, hi-lo)
+	copy(elems, left.(*object.Array).Elements[lo:hi])
+	return &object.Array{Elements: elems}
+}
+
+func boundString(bound object.Object) string {
+	if _, ok := bound.(*object.Nil); ok {
+		return ""
+	}
+	return bound.Inspect()
+}
+
+func (e *Evaluator) evalHashLiteral(env *object.Environment, node *ast.HashLiteral) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair, len(node.Pairs))
 	for keyNode, valueNode := range node.Pairs {
-		key := Eval(env, keyNode)
+		key := e.evalNode(env, keyNode)
 		if isError(key) {
 			return key
 		}
 
-		hasher, ok := key.(object.Hasher)
+		hashed, ok := object.HashKeyOf(key)
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
 
-		value := Eval(env, valueNode)
+		value := e.evalNode(env, valueNode)
 		if isError(value) {
 			return value
 		}
 
-		hashed := hasher.HashKey()
 		pairs[hashed] = object.HashPair{Key: key, Value: value}
 	}
 
@@ -351,19 +567,26 @@ func evalBangOperatorExpression(obj object.Object) object.Object {
 func evalMinusOperatorExpression(obj object.Object) object.Object {
 	switch number := obj.(type) {
 	case *object.Integer:
-		return &object.Integer{-number.Value}
+		if number.Value == math.MinInt64 {
+			return object.NewInteger(new(big.Int).Neg(big.NewInt(number.Value)))
+		}
+		return &object.Integer{Value: -number.Value}
+	case *object.BigInteger:
+		return object.NewInteger(new(big.Int).Neg(number.Value))
+	case *object.Float:
+		return &object.Float{Value: -number.Value}
 	default:
 		return newError("unknown operator: -%s", obj.Type())
 	}
 }
 
-func evalLazyInfixExpression(env *object.Environment, node *ast.InfixExpression) object.Object {
+func (e *Evaluator) evalLazyInfixExpression(env *object.Environment, node *ast.InfixExpression) object.Object {
 
 	switch node.Operator {
 	case "&&":
-		return and(env, node.Left, node.Right)
+		return e.and(env, node.Left, node.Right)
 	case "||":
-		return or(env, node.Left, node.Right)
+		return e.or(env, node.Left, node.Right)
 	}
 
 	return NULL
@@ -371,6 +594,16 @@ func evalLazyInfixExpression(env *object.Environment, node *ast.InfixExpression)
 
 func evalInfixExpression(operator string, left, right object.Object) object.Object {
 	switch {
+	case isNumber(left) && isNumber(right) &&
+		(left.Type() == object.FLOAT_OBJ || right.Type() == object.FLOAT_OBJ):
+		return evalFloatInfixExpression(operator, left, right)
+
+	// Values of any types can be compared for equality.
+	case operator == "==" && !(isNumber(left) && isNumber(right)):
+		return nativeBooleanToObject(equal(left, right))
+	case operator == "!=" && !(isNumber(left) && isNumber(right)):
+		return nativeBooleanToObject(!equal(left, right))
+
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -381,46 +614,247 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
 		return evalStringInfixExpression(operator, left, right)
 
-	case operator == "==":
-		return &object.Boolean{left == right}
-	case operator == "!=":
-		return &object.Boolean{left != right}
+	case left.Type() == object.ARRAY_OBJ && right.Type() == object.ARRAY_OBJ:
+		return evalArrayInfixExpression(operator, left, right)
 	}
 
 	return newError("unknown operator: %s %s %s",
 		left.Type(), operator, right.Type())
 }
 
+// equal reports whether a == b. Values of different types are never
+// equal, unless both are numbers. Arrays and hashes are equal when their
+// elements are, and functions only to themselves.
+func equal(a, b object.Object) bool {
+	if isNumber(a) && isNumber(b) {
+		eq, ok := evalInfixExpression("==", a, b).(*object.Boolean)
+		return ok && eq.Value
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a := a.(type) {
+	case *object.String:
+		return a.Value == b.(*object.String).Value
+	case *object.Boolean:
+		return a.Value == b.(*object.Boolean).Value
+	case *object.Nil:
+		return true
+	case *object.Array:
+		b := b.(*object.Array)
+		if len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i, el := range a.Elements {
+			if !equal(el, b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		b := b.(*object.Hash)
+		if len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		for key, pair := range a.Pairs {
+			other, ok := b.Pairs[key]
+			if !ok || !equal(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	case *object.Range:
+		// Ranges are equal when they have the same elements.
+		b := b.(*object.Range)
+		n := a.Len()
+		return n == b.Len() && (n == 0 || a.Start == b.Start && (n == 1 || a.Step == b.Step))
+	}
+
+	return a == b
+}
+
+// evalArrayInfixExpression orders arrays lexicographically: by the first
+// elements that are not equal or, when there are none, by length.
+func evalArrayInfixExpression(operator string, left, right object.Object) object.Object {
+	l := left.(*object.Array).Elements
+	r := right.(*object.Array).Elements
+
+	var strict string
+	switch operator {
+	case "<", "<=":
+		strict = "<"
+	case ">", ">=":
+		strict = ">"
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+
+	for i := 0; i < len(l) && i < len(r); i++ {
+		if !equal(l[i], r[i]) {
+			return evalInfixExpression(strict, l[i], r[i])
+		}
+	}
+
+	switch operator {
+	case "<":
+		return nativeBooleanToObject(len(l) < len(r))
+	case "<=":
+		return nativeBooleanToObject(len(l) <= len(r))
+	case ">":
+		return nativeBooleanToObject(len(l) > len(r))
+	default:
+		return nativeBooleanToObject(len(l) >= len(r))
+	}
+}
+
+// evalIntegerInfixExpression operates on two integers. Results that
+// overflow an int64 are computed again with big integers.
 func evalIntegerInfixExpression(operator string, left, right object.Object) object.Object {
 	l, lok := left.(*object.Integer)
 	r, rok := right.(*object.Integer)
-
 	if !lok || !rok {
-		return NULL
+		return evalBigIntegerInfixExpression(operator, left, right)
 	}
 
 	switch operator {
 	// Returns number.
 	case "+":
-		return &object.Integer{l.Value + r.Value}
+		sum := l.Value + r.Value
+		if (sum > l.Value) == (r.Value > 0) {
+			return &object.Integer{Value: sum}
+		}
 	case "-":
-		return &object.Integer{l.Value - r.Value}
+		diff := l.Value - r.Value
+		if (diff < l.Value) == (r.Value > 0) {
+			return &object.Integer{Value: diff}
+		}
 	case "*":
-		return &object.Integer{l.Value * r.Value}
+		if l.Value == 0 || r.Value == 0 {
+			return &object.Integer{Value: 0}
+		}
+		product := l.Value * r.Value
+		if product/r.Value == l.Value && !(l.Value == -1 && r.Value == math.MinInt64) &&
+			!(r.Value == -1 && l.Value == math.MinInt64) {
+			return &object.Integer{Value: product}
+		}
 	case "/":
-		return &object.Integer{l.Value / r.Value}
+		if r.Value == 0 {
+			return newError("division by zero")
+		}
+		if !(l.Value == math.MinInt64 && r.Value == -1) {
+			return &object.Integer{Value: l.Value / r.Value}
+		}
 	case "%":
-		return &object.Integer{l.Value % r.Value}
+		if r.Value == 0 {
+			return newError("modulo by zero")
+		}
+		return &object.Integer{Value: l.Value % r.Value}
 
 	// Returns boolean.
 	case "<":
 		return nativeBooleanToObject(l.Value < r.Value)
 	case ">":
 		return nativeBooleanToObject(l.Value > r.Value)
+	case "<=":
+		return nativeBooleanToObject(l.Value <= r.Value)
+	case ">=":
+		return nativeBooleanToObject(l.Value >= r.Value)
 	case "==":
 		return nativeBooleanToObject(l.Value == r.Value)
 	case "!=":
 		return nativeBooleanToObject(l.Value != r.Value)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+
+	// Overflowed.
+	return evalBigIntegerInfixExpression(operator, left, right)
+}
+
+func evalBigIntegerInfixExpression(operator string, left, right object.Object) object.Object {
+	l, r := toBig(left), toBig(right)
+
+	switch operator {
+	// Returns number.
+	case "+":
+		return object.NewInteger(new(big.Int).Add(l, r))
+	case "-":
+		return object.NewInteger(new(big.Int).Sub(l, r))
+	case "*":
+		return object.NewInteger(new(big.Int).Mul(l, r))
+	case "/":
+		if r.Sign() == 0 {
+			return newError("division by zero")
+		}
+		// Truncates like int64 division does.
+		return object.NewInteger(new(big.Int).Quo(l, r))
+	case "%":
+		if r.Sign() == 0 {
+			return newError("modulo by zero")
+		}
+		return object.NewInteger(new(big.Int).Rem(l, r))
+
+	// Returns boolean.
+	case "<":
+		return nativeBooleanToObject(l.Cmp(r) < 0)
+	case ">":
+		return nativeBooleanToObject(l.Cmp(r) > 0)
+	case "<=":
+		return nativeBooleanToObject(l.Cmp(r) <= 0)
+	case ">=":
+		return nativeBooleanToObject(l.Cmp(r) >= 0)
+	case "==":
+		return nativeBooleanToObject(l.Cmp(r) == 0)
+	case "!=":
+		return nativeBooleanToObject(l.Cmp(r) != 0)
+	}
+
+	return newError("unknown operator: %s %s %s",
+		left.Type(), operator, right.Type())
+}
+
+// evalFloatInfixExpression operates on two numbers, at least one of them a
+// float. Integers are converted to floats first.
+func evalFloatInfixExpression(operator string, left, right object.Object) object.Object {
+	l, r := toFloat(left), toFloat(right)
+
+	switch operator {
+	// Returns number.
+	case "+":
+		return &object.Float{Value: l + r}
+	case "-":
+		return &object.Float{Value: l - r}
+	case "*":
+		return &object.Float{Value: l * r}
+	case "/":
+		// Dividing by zero is an error for floats too, so it doesn't
+		// depend on the type of the operands.
+		if r == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: l / r}
+	case "%":
+		if r == 0 {
+			return newError("modulo by zero")
+		}
+		return &object.Float{Value: math.Mod(l, r)}
+
+	// Returns boolean.
+	case "<":
+		return nativeBooleanToObject(l < r)
+	case ">":
+		return nativeBooleanToObject(l > r)
+	case "<=":
+		return nativeBooleanToObject(l <= r)
+	case ">=":
+		return nativeBooleanToObject(l >= r)
+	case "==":
+		return nativeBooleanToObject(l == r)
+	case "!=":
+		return nativeBooleanToObject(l != r)
 	}
 
 	return newError("unknown operator: %s %s %s",
@@ -438,41 +872,209 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	switch operator {
 	// Returns concatenated string.
 	case "+":
-		return &object.String{fmt.Sprintf("%s%s", l.Value, r.Value)}
+		return &object.String{Value: fmt.Sprintf("%s%s", l.Value, r.Value)}
+
+	// Returns boolean. Strings compare byte by byte, which for UTF-8
+	// is the order of their characters.
+	case "<":
+		return nativeBooleanToObject(l.Value < r.Value)
+	case ">":
+		return nativeBooleanToObject(l.Value > r.Value)
+	case "<=":
+		return nativeBooleanToObject(l.Value <= r.Value)
+	case ">=":
+		return nativeBooleanToObject(l.Value >= r.Value)
+	case "==":
+		return nativeBooleanToObject(l.Value == r.Value)
+	case "!=":
+		return nativeBooleanToObject(l.Value != r.Value)
 	}
 
 	return newError("unknown operator: %s %s %s",
 		left.Type(), operator, right.Type())
 }
 
-func evalIfExpression(env *object.Environment, expr *ast.IfExpression) object.Object {
-	return try(Eval(env, expr.Condition), func(pred object.Object) object.Object {
+// evalAssignExpression assigns to a bound variable, in the environment
+// where it is bound, which may be an outer one. The value of the
+// assignment is the new value.
+func (e *Evaluator) evalAssignExpression(env *object.Environment, node *ast.AssignExpression) object.Object {
+	name := node.Name.Value
+	scope, ok := env.Resolve(name)
+	if !ok {
+		return newError("cannot assign to undeclared variable: %s", name)
+	}
+	if scope.IsConst(name) {
+		return newError("cannot assign to constant: %s", name)
+	}
+
+	return try(e.evalNode(env, node.Value), func(val object.Object) object.Object {
+		if node.Operator != "=" {
+			// x += y is x = x + y, and so on.
+			old, _ := scope.Get(name)
+			val = e.allocSized(evalInfixExpression(strings.TrimSuffix(node.Operator, "="), old, val))
+			if isError(val) {
+				return val
+			}
+		}
+		scope.Set(name, val)
+		return val
+	})
+}
+
+// interpolate joins the values of the parts of an interpolated string.
+func interpolate(parts []object.Object) object.Object {
+	var out strings.Builder
+	for _, part := range parts {
+		out.WriteString(toStr(part))
+	}
+	return &object.String{Value: out.String()}
+}
+
+func (e *Evaluator) evalIfExpression(env *object.Environment, expr *ast.IfExpression) object.Object {
+	return try(e.evalNode(env, expr.Condition), func(pred object.Object) object.Object {
 		if isTruthy(pred) {
-			return Eval(env, expr.Consequence)
+			return e.evalNode(env, expr.Consequence)
 		} else if expr.Alternative != nil {
-			return Eval(env, expr.Alternative)
+			return e.evalNode(env, expr.Alternative)
 		}
 		return NULL
 	})
 }
 
-func evalIdentifier(env *object.Environment, node *ast.Identifier) object.Object {
+// evalMatchExpression evaluates the first arm of a match expression that
+// matches, binding the names in its pattern in an environment of its own,
+// enclosed by env. The body of the arm is evaluated with evalBody, so
+// calls in tail position can be left to applyFunction.
+func (e *Evaluator) evalMatchExpression(env *object.Environment, node *ast.MatchExpression,
+	evalBody func(*object.Environment, ast.Expression) object.Object) object.Object {
+	return try(e.evalNode(env, node.Value), func(val object.Object) object.Object {
+		for _, arm := range node.Arms {
+			var values []object.Object
+			if !match(arm.Pattern, val, &values) {
+				continue
+			}
+			armEnv := object.NewEnclosedEnvironment(env)
+			for i, name := range ast.Bindings(arm.Pattern) {
+				armEnv.Set(name.Value, values[i])
+			}
+
+			if arm.Guard != nil {
+				guard := e.evalNode(armEnv, arm.Guard)
+				if isError(guard) {
+					return guard
+				}
+				if !isTruthy(guard) {
+					continue
+				}
+			}
+
+			return evalBody(armEnv, arm.Body)
+		}
+
+		// Tagged here, as match expressions in tail position aren't
+		// evaluated by evalNode.
+		err := noMatch(val)
+		err.Pos = node.Pos()
+		err.Stack = e.trace(err.Pos)
+		return err
+	})
+}
+
+func (e *Evaluator) evalWhileStatement(env *object.Environment, node *ast.WhileStatement) object.Object {
+	for {
+		cond := e.evalNode(env, node.Condition)
+		if isError(cond) {
+			return cond
+		}
+		if !isTruthy(cond) {
+			return NULL
+		}
+		if result, done := e.evalLoopBody(env, node.Body); done {
+			return result
+		}
+	}
+}
+
+// evalForStatement binds the variable of the loop to each element of the
+// iterable in turn, in an environment of its own enclosed by env, where
+// the body runs too.
+func (e *Evaluator) evalForStatement(env *object.Environment, node *ast.ForStatement) object.Object {
+	return try(e.evalNode(env, node.Iterable), func(iterable object.Object) object.Object {
+		result := iterate(iterable)
+		it, ok := result.(*Iterator)
+		if !ok {
+			return result
+		}
+
+		// Arrays and hashes hold their elements already, while the
+		// characters of strings and the integers of ranges are created
+		// while iterating.
+		fresh := iterable.Type() == object.STRING_OBJ || iterable.Type() == object.RANGE_OBJ
+
+		loopEnv := object.NewEnclosedEnvironment(env)
+		for {
+			el, ok := it.Next()
+			if !ok {
+				return NULL
+			}
+			if fresh {
+				if el = e.alloc(el); isError(el) {
+					return el
+				}
+			}
+			loopEnv.Set(node.Variable.Value, el)
+			if result, done := e.evalLoopBody(loopEnv, node.Body); done {
+				return result
+			}
+		}
+	})
+}
+
+// evalLoopBody runs the body of a loop once. It reports whether the loop
+// is done, because of a break, return or error, along with the result
+// of the loop then.
+func (e *Evaluator) evalLoopBody(env *object.Environment, body *ast.BlockStatement) (object.Object, bool) {
+	result := e.evalNode(env, body)
+	switch {
+	case result == breakLoop:
+		return NULL, true
+	case result.Type() == object.RETURN_VALUE_OBJ || result.Type() == object.ERROR_OBJ:
+		return result, true
+	}
+	return nil, false
+}
+
+// loopControl is the result of a break or continue statement, which ends
+// the blocks it is in, like a return statement, up to its loop.
+type loopControl struct {
+	keyword string
+}
+
+func (lc *loopControl) Type() object.ObjectType { return "LOOP_CONTROL" }
+func (lc *loopControl) Inspect() string         { return lc.keyword }
+
+var (
+	breakLoop    = &loopControl{"break"}
+	continueLoop = &loopControl{"continue"}
+)
+
+func (e *Evaluator) evalIdentifier(env *object.Environment, node *ast.Identifier) object.Object {
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
 
-	if builtin, ok := builtins[node.Value]; ok {
+	if builtin, ok := e.builtins[node.Value]; ok {
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return newError("identifier not found: %s", node.Value)
 }
 
-func evalExpressions(env *object.Environment, exprs []ast.Expression) []object.Object {
+func (e *Evaluator) evalExpressions(env *object.Environment, exprs []ast.Expression) []object.Object {
 	var result []object.Object
 
-	for _, e := range exprs {
-		evaluated := Eval(env, e)
+	for _, expr := range exprs {
+		evaluated := e.evalNode(env, expr)
 		if isError(evaluated) {
 			return []object.Object{evaluated}
 		}
@@ -482,27 +1084,161 @@ func evalExpressions(env *object.Environment, exprs []ast.Expression) []object.O
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
-	switch fn := fn.(type) {
-	case *object.Function:
-		// Extends environment.
-		newEnv := object.NewEnclosedEnvironment(fn.Env)
-		if len(fn.Parameters) != len(args) {
-			return newError("argument mismatch: got %d, want %d",
-				len(args), len(fn.Parameters))
+// applyFunction calls fn with the given arguments; call is the position
+// of the call expression.
+//
+// Calls in tail position of a function come back from its body as a
+// *tailCall and are made here, replacing the frame of the function that
+// made them, so loops written as recursion run in constant stack space.
+func (e *Evaluator) applyFunction(call token.Position, fn object.Object, args []object.Object) object.Object {
+	base := len(e.stack)
+	tailPos := call
+
+	for {
+		var result object.Object
+
+		switch fn := fn.(type) {
+		case *object.Function:
+			if len(fn.Parameters) != len(args) {
+				result = newError("argument mismatch: got %d, want %d",
+					len(args), len(fn.Parameters))
+				break
+			}
+			if e.limits.MaxDepth > 0 && base >= e.limits.MaxDepth {
+				result = newLimitError(object.DepthLimitError,
+					"call depth limit exceeded: %d", e.limits.MaxDepth)
+				break
+			}
+			if err := e.step(); err != nil {
+				result = err
+				break
+			}
+			// Extends environment.
+			newEnv := object.NewEnclosedEnvironment(fn.Env)
+			for paramIdx, param := range fn.Parameters {
+				newEnv.Set(param.Value, args[paramIdx])
+			}
+			// Evaluates it.
+			e.stack = append(e.stack[:base], frame{function: fn.Name, call: call})
+			result = unwrapReturnValue(e.evalTailBlock(newEnv, fn.Body, true))
+
+		case *object.Builtin:
+			outer := e.builtinCall
+			e.builtinCall = tailPos
+			result = e.alloc(callBuiltin(e, fn, args))
+			e.builtinCall = outer
+
+		default:
+			result = newError("not a function: %s", fn.Type())
 		}
-		for paramIdx, param := range fn.Parameters {
-			newEnv.Set(param.Value, args[paramIdx])
+
+		tc, ok := result.(*tailCall)
+		if !ok {
+			if len(e.stack) > base {
+				// Errors making a tail call belong to the function that
+				// made it, whose frame is still on the stack.
+				if err, ok := result.(*object.Error); ok && err.Stack == nil {
+					err.Pos = tailPos
+					err.Stack = e.trace(tailPos)
+				}
+				e.stack = e.stack[:base]
+			}
+			return result
 		}
-		// Evaluates it.
-		evaluated := Eval(newEnv, fn.Body)
-		return unwrapReturnValue(evaluated)
+		fn, args, tailPos = tc.fn, tc.args, tc.call
+	}
+}
+
+// tailCall is a call in tail position, left for applyFunction to make.
+type tailCall struct {
+	fn   object.Object
+	args []object.Object
+	call token.Position
+}
 
-	case *object.Builtin:
-		return fn.Fn(args...)
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// evalTailBlock evaluates the body of a function, or a branch of an if
+// expression in it. Calls in tail position are returned as a *tailCall
+// instead of being made: those are the values of return statements and,
+// when tail is true, the value of the last statement.
+func (e *Evaluator) evalTailBlock(env *object.Environment, block *ast.BlockStatement, tail bool) object.Object {
+	var result object.Object = NULL
+	for i, s := range block.Statements {
+		result = e.evalTailStatement(env, s, tail && i == len(block.Statements)-1)
+		if result != nil {
+			if result.Type() == object.RETURN_VALUE_OBJ ||
+				result.Type() == object.ERROR_OBJ {
+				return result
+			}
+		}
 	}
+	return result
+}
+
+func (e *Evaluator) evalTailStatement(env *object.Environment, stmt ast.Statement, tail bool) object.Object {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement:
+		return try(e.evalTailExpression(env, stmt.Value, true), func(val object.Object) object.Object {
+			return &object.ReturnValue{Value: val}
+		})
+
+	case *ast.ExpressionStatement:
+		return e.evalTailExpression(env, stmt.Expression, tail)
+	}
+
+	return e.evalNode(env, stmt)
+}
+
+func (e *Evaluator) evalTailExpression(env *object.Environment, expr ast.Expression, tail bool) object.Object {
+	switch expr := expr.(type) {
+	case *ast.IfExpression:
+		// Return statements in the branches are in tail position even
+		// when the if expression isn't.
+		return try(e.evalNode(env, expr.Condition), func(pred object.Object) object.Object {
+			if isTruthy(pred) {
+				return e.evalTailBlock(env, expr.Consequence, tail)
+			} else if expr.Alternative != nil {
+				return e.evalTailBlock(env, expr.Alternative, tail)
+			}
+			return NULL
+		})
+
+	case *ast.MatchExpression:
+		return e.evalMatchExpression(env, expr, func(env *object.Environment, body ast.Expression) object.Object {
+			return e.evalTailExpression(env, body, tail)
+		})
+
+	case *ast.CallExpression:
+		if tail {
+			return try(e.evalNode(env, expr.Function), func(f object.Object) object.Object {
+				args := e.evalExpressions(env, expr.Arguments)
+				if len(args) >= 1 && isError(args[0]) {
+					return args[0]
+				}
+				return &tailCall{fn: f, args: args, call: expr.Pos()}
+			})
+		}
+	}
+
+	return e.evalNode(env, expr)
+}
+
+// callBuiltin calls a builtin function, turning a panic in it into an
+// error so it doesn't take down the program embedding the interpreter.
+func callBuiltin(rt object.Runtime, fn *object.Builtin, args []object.Object) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("builtin function panicked: %v", r)
+		}
+	}()
 
-	return newError("not a function: %s", fn.Type())
+	result = fn.Fn(rt, args...)
+	if result == nil {
+		return NULL
+	}
+	return result
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -512,6 +1248,76 @@ func unwrapReturnValue(obj object.Object) object.Object {
 	return obj
 }
 
+// trace returns the call stack, innermost call first, as seen from the
+// given position in the current function.
+func (e *Evaluator) trace(pos token.Position) []object.Frame {
+	trace := make([]object.Frame, 0, len(e.stack)+1)
+	for i := len(e.stack) - 1; i >= 0; i-- {
+		trace = append(trace, object.Frame{Function: e.stack[i].function, Pos: pos})
+		pos = e.stack[i].call
+	}
+	return append(trace, object.Frame{Function: "main", Pos: pos})
+}
+
+// step counts one step of evaluation, returning an error if the evaluation
+// has to stop.
+func (e *Evaluator) step() *object.Error {
+	e.steps++
+	if e.limits.MaxSteps > 0 && e.steps > e.limits.MaxSteps {
+		return newLimitError(object.StepLimitError,
+			"step limit exceeded: %d", e.limits.MaxSteps)
+	}
+	if e.steps%checkInterval == 0 && e.ctx != nil {
+		switch e.ctx.Err() {
+		case nil:
+		case context.DeadlineExceeded:
+			return newLimitError(object.DeadlineError, "deadline exceeded")
+		default:
+			return newLimitError(object.CanceledError, "evaluation canceled")
+		}
+	}
+	return nil
+}
+
+// alloc counts obj as created, unless it is a shared object, returning an
+// error instead if that goes over the limit.
+func (e *Evaluator) alloc(obj object.Object) object.Object {
+	switch obj {
+	case nil, NULL, TRUE, FALSE:
+		return obj
+	}
+	if isError(obj) {
+		return obj
+	}
+
+	if err := e.Alloc(1); err != nil {
+		return err
+	}
+	return obj
+}
+
+// allocSized is like alloc, but counts strings, arrays and big integers
+// by their size, for the values made by operators, which can be as large
+// as their operands together.
+func (e *Evaluator) allocSized(obj object.Object) object.Object {
+	var n int
+	switch obj := obj.(type) {
+	case *object.String:
+		n = utf8.RuneCountInString(obj.Value)
+	case *object.Array:
+		n = len(obj.Elements)
+	case *object.BigInteger:
+		n = len(obj.Value.Bits())
+	default:
+		return e.alloc(obj)
+	}
+
+	if err := e.Alloc(int64(n) + 1); err != nil {
+		return err
+	}
+	return obj
+}
+
 // Helper functions.
 
 // Checks the given object, if it's an error, returns it;
@@ -524,21 +1330,21 @@ func try(obj object.Object, do func(object.Object) object.Object) object.Object
 	return do(obj)
 }
 
-func and(env *object.Environment, lhs, rhs ast.Expression) object.Object {
-	return try(Eval(env, lhs), func(left object.Object) object.Object {
+func (e *Evaluator) and(env *object.Environment, lhs, rhs ast.Expression) object.Object {
+	return try(e.evalNode(env, lhs), func(left object.Object) object.Object {
 		if isTruthy(left) {
-			return Eval(env, rhs)
+			return e.evalNode(env, rhs)
 		}
 		return left
 	})
 }
 
-func or(env *object.Environment, lhs, rhs ast.Expression) object.Object {
-	return try(Eval(env, lhs), func(left object.Object) object.Object {
+func (e *Evaluator) or(env *object.Environment, lhs, rhs ast.Expression) object.Object {
+	return try(e.evalNode(env, lhs), func(left object.Object) object.Object {
 		if isTruthy(left) {
 			return left
 		}
-		return Eval(env, rhs)
+		return e.evalNode(env, rhs)
 	})
 }
 
@@ -562,6 +1368,48 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Integer, *object.BigInteger, *object.Float:
+		return true
+	}
+	return false
+}
+
+// toFloat returns the value of a number as a float.
+func toFloat(obj object.Object) float64 {
+	switch n := obj.(type) {
+	case *object.Integer:
+		return float64(n.Value)
+	case *object.BigInteger:
+		f, _ := new(big.Float).SetInt(n.Value).Float64()
+		return f
+	case *object.Float:
+		return n.Value
+	}
+	return math.NaN()
+}
+
+// toStr returns obj as text, which is the value of strings and what
+// Inspect returns for everything else.
+func toStr(obj object.Object) string {
+	if str, ok := obj.(*object.String); ok {
+		return str.Value
+	}
+	return obj.Inspect()
+}
+
+// toBig returns the value of an integer as a big integer.
+func toBig(obj object.Object) *big.Int {
+	switch n := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(n.Value)
+	case *object.BigInteger:
+		return n.Value
+	}
+	return new(big.Int)
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -572,3 +1420,70 @@ func isError(obj object.Object) bool {
 func newError(format string, args ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, args...)}
 }
+
+func newLimitError(kind object.ErrorKind, format string, args ...interface{}) *object.Error {
+	return &object.Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// Operations shared with the virtual machine, so both give the same
+// results.
+
+// EvalPrefix applies a prefix operator to an already evaluated operand.
+func EvalPrefix(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+// EvalInfix applies a non-logical infix operator to already evaluated
+// operands.
+func EvalInfix(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+// EvalIndex indexes left with index, failing for indexes out of range in
+// strict mode.
+func EvalIndex(left, index object.Object, strict bool) object.Object {
+	return evalIndexExpression(left, index, strict)
+}
+
+// EvalSlice slices left from low to high, which are null when left out,
+// failing for bounds out of range in strict mode.
+func EvalSlice(left, low, high object.Object, strict bool) object.Object {
+	return evalSliceExpression(left, low, high, strict)
+}
+
+// CallBuiltin calls fn with the given runtime and arguments. Panics in fn
+// are returned as errors.
+func CallBuiltin(rt object.Runtime, fn *object.Builtin, args ...object.Object) object.Object {
+	return callBuiltin(rt, fn, args)
+}
+
+// Interpolate joins the values of the parts of an interpolated string, as
+// the str builtin converts them.
+func Interpolate(parts ...object.Object) object.Object {
+	return interpolate(parts)
+}
+
+// Iterate returns an *Iterator over obj, or an error if obj can't be
+// iterated over.
+func Iterate(obj object.Object) object.Object {
+	return iterate(obj)
+}
+
+// Match matches value against a pattern. If it matches, it returns the
+// values bound to the names of ast.Bindings(p), in the same order.
+func Match(p ast.Pattern, value object.Object) ([]object.Object, bool) {
+	var values []object.Object
+	ok := match(p, value, &values)
+	return values, ok
+}
+
+// NoMatch returns the error for a value no arm of a match expression
+// matched.
+func NoMatch(value object.Object) *object.Error {
+	return noMatch(value)
+}
+
+// IsTruthy reports whether obj counts as true in a condition.
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
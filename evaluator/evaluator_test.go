@@ -1,7 +1,16 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/danielrs/monkey/lexer"
 	"github.com/danielrs/monkey/object"
@@ -74,6 +83,102 @@ func TestEvalInfixExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3.0},
+		{"1 + 0.5", 1.5},
+		{"0.5 + 1", 1.5},
+		{"5 / 2.0", 2.5},
+		{"5 / 2", 2},
+		{"7.5 % 2", 1.5},
+		{"2 * 1e3", 2000.0},
+		{"1 < 1.5", true},
+		{"2.0 > 3", false},
+		{"1 == 1.0", true},
+		{"1.5 != 1.5", false},
+		{`{1: "one"}[1.0]`, "one"},
+		{`{1.5: "a"}[1.5]`, "a"},
+		{`{1e20: "a"}[100000000000000000000]`, "a"},
+		{`{100000000000000000000: "a"}[1e20]`, "a"},
+		{`{-1e20: "a"}[-100000000000000000000]`, "a"},
+		{`{1e20: "a"}[100000000000000000001]`, nil},
+		{"int(3.9)", 3},
+		{"int(-3.9)", -3},
+		{`int(" 42 ")`, 42},
+		{"int(7)", 7},
+		{"float(2)", 2.0},
+		{`float("1e-3")`, 0.001},
+		{"float(0.5)", 0.5},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		testObject(t, obj, tt.expected)
+	}
+}
+
+func TestEvalBigIntegerExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"9223372036854775807 + 1", bigInt("9223372036854775808")},
+		{"-9223372036854775807 - 2", bigInt("-9223372036854775809")},
+		{"4294967296 * 4294967296", bigInt("18446744073709551616")},
+		{"-(-9223372036854775807 - 1)", bigInt("9223372036854775808")},
+		{"100000000000000000000", bigInt("100000000000000000000")},
+		{"100000000000000000000 - 99999999999999999999", 1},
+		{"100000000000000000000 / 3", bigInt("33333333333333333333")},
+		{"-100000000000000000000 / 3", bigInt("-33333333333333333333")},
+		{"-100000000000000000001 % 10", -1},
+		{"(9223372036854775807 + 1) / 2", 4611686018427387904},
+		{"100000000000000000000 > 1", true},
+		{"1 < -100000000000000000000", false},
+		{"100000000000000000000 == 100000000000000000000", true},
+		{"100000000000000000000 != 100000000000000000001", true},
+		{"100000000000000000000 == 1e20", true},
+		{"100000000000000000000 * 0.5", 5e19},
+		{`{100000000000000000000: "a"}[99999999999999999999 + 1]`, "a"},
+		{`{-100000000000000000000: "a"}[100000000000000000000]`, nil},
+		{"int(1e20)", bigInt("100000000000000000000")},
+		{`int("-100000000000000000000")`, bigInt("-100000000000000000000")},
+		{"float(100000000000000000000)", 1e20},
+		{"let f = fn(n) { if (n < 2) { 1 } else { n * f(n - 1) } }; f(25)",
+			bigInt("15511210043330985984000000")},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		testObject(t, obj, tt.expected)
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1.0", "1.0"},
+		{"0.1 + 0.2", "0.30000000000000004"},
+		{"-0.25", "-0.25"},
+		{"1e21", "1e+21"},
+		{"1e-7", "1e-07"},
+		{"1e308 * 10", "+Inf"},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		if obj.Inspect() != tt.expected {
+			t.Errorf("%q inspects as %s, want %s", tt.input, obj.Inspect(), tt.expected)
+		}
+	}
+}
+
 func TestBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -102,6 +207,99 @@ func TestBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"1.5 <= 1", false},
+		{"1 >= 0.5", true},
+		{"100000000000000000000 >= 100000000000000000000", true},
+		{"-100000000000000000000 <= 1", true},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"ab" > "a"`, true},
+		{`"a" <= "a"`, true},
+		{`"a" >= "b"`, false},
+		{`"é" > "z"`, true},
+		{`"foo" == "foo"`, true},
+		{`"foo" != "bar"`, true},
+		// Values of different types are unequal rather than a type
+		// mismatch, so missing values can be compared with anything.
+		{`1 == "1"`, false},
+		{`1 != "1"`, true},
+		{"[1] == {}", false},
+		{"true != 1", true},
+		{`{"a": 1}["b"] == 0`, false},
+		{`{"a": 1}["b"] == {}["c"]`, true},
+		// Arrays are ordered lexicographically.
+		{"[1, 2] < [1, 3]", true},
+		{"[1, 2] < [1, 2]", false},
+		{"[1, 2] <= [1, 2]", true},
+		{"[1] < [1, 0]", true},
+		{"[] < [1]", true},
+		{"[2] > [1, 5]", true},
+		{"[1, 2] >= [1]", true},
+		{`[1, "b"] > [1, "a"]`, true},
+		{`[[1, 2], "x"] < [[1, 3]]`, true},
+		{`[{"a": 1}, 1] < [{"a": 1}, 2]`, true},
+		{"[1.5] < [2]", true},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		if !testBooleanObject(t, obj, tt.expected) {
+			t.Log(tt.input)
+		}
+	}
+}
+
+func TestEquality(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"[1, 2] == [1, 2]", true},
+		{"[1, 2] == [2, 1]", false},
+		{"[1, 2] == [1, 2, 3]", false},
+		{"[] == []", true},
+		{"[1, [2, [3]]] == [1, [2, [3]]]", true},
+		{"[1] == [1.0]", true},
+		{"[1, 2] != [1, 2]", false},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} == {"b": 1}`, false},
+		{`{"a": 1} == {"a": 1, "b": 2}`, false},
+		{"{} == {}", true},
+		{"{1: true} == {1.0: true}", true},
+		// Functions are only equal to themselves.
+		{"let f = fn() { 1 }; f == f", true},
+		{"fn() { 1 } == fn() { 1 }", false},
+		{"len == len", true},
+		{"len == push", false},
+		{"let f = fn() { 1 }; [f] == [f]", true},
+		// Nil is equal to nil, and different types are never equal.
+		{"fn() { }() == fn() { }()", true},
+		{"fn() { let x = 1 }() == fn() { }()", true},
+		{"fn() { }() == false", false},
+		{"fn() { }() != 0", true},
+		{`1 == "1"`, false},
+		{`"1" != 1`, true},
+		{"[] == {}", false},
+		{"true == 1", false},
+		// Ranges are equal when they have the same elements.
+		{"range(3) == range(0, 3, 1)", true},
+		{"range(0) == range(5, 0)", true},
+		{"range(2, 3) == range(2, 10, 8)", true},
+		{"range(3) == range(4)", false},
+		{"range(3) == [0, 1, 2]", false},
+		// Equality is used by the builtins.
+		{"contains([[1, 2], [3]], [3])", true},
+		{`index_of([{"a": 1}], {"a": 1}) == 0`, true},
+		{"sort([[2, 1], [1, 2], [1]]) == [[1], [1, 2], [2, 1]]", true},
+		{`match ([1, 2]) { [1, 2] => true, _ => false }`, true},
+		// Statements have no value.
+		{"if (fn() { let x = 1 }()) { true } else { false }", false},
 	}
 
 	for _, tt := range tests {
@@ -166,6 +364,174 @@ func TestLetStatement(t *testing.T) {
 	}
 }
 
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = 5; a += 2; a", 7},
+		{"let a = 5; a -= 2; a", 3},
+		{"let a = 5; a *= 2; a", 10},
+		{"let a = 5; a /= 2; a", 2},
+		{"let a = 5; a %= 2; a", 1},
+		{"let a = 1.5; a *= 2; a", 3.0},
+		{`let s = "foo"; s += "bar"; s`, "foobar"},
+		{"let a = 5; a += 2", 7},
+		{"let a = 1; let b = 2; a += b += 3; a", 6},
+		{"let a = 9223372036854775807; a += 1; a > 9223372036854775807", true},
+		// Assignments change the variable where it is bound.
+		{"let a = 1; let f = fn() { a += 1 }; f(); f(); a", 3},
+		{"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c()", 2},
+		{"let a = 1; let f = fn(a) { a += 1 }; f(10); a", 1},
+		{"let a = 1; a = 2; a", 2},
+		{"let a = 1; let b = 2; a = b = 3; a + b", 6},
+		{"let a = 1; let f = fn() { a = a * 10 }; f(); f(); a", 100},
+		{"let counter = fn() { let n = 0; fn() { n = n + 1 } }; let c = counter(); c(); c()", 2},
+		{`let a = 1; a = "one"; a`, "one"},
+		// Constants can be redeclared, but not assigned to.
+		{"const a = 1; a", 1},
+		{"const a = 1; let a = 2; a = 3; a", 3},
+		{"const a = 1; let f = fn() { let a = 2; a = 3; a }; f()", 3},
+		// Errors.
+		{"a = 1", errors.New("cannot assign to undeclared variable: a")},
+		{"a += 1", errors.New("cannot assign to undeclared variable: a")},
+		{"len += 1", errors.New("cannot assign to undeclared variable: len")},
+		{"let f = fn() { b = 1 }; f()", errors.New("cannot assign to undeclared variable: b")},
+		{"x = 5; let x = 1; x", errors.New("cannot assign to undeclared variable: x")},
+		{"fn() { x += 1; let x = 0; x }()", errors.New("cannot assign to undeclared variable: x")},
+		{"c = 1; const c = 2", errors.New("cannot assign to undeclared variable: c")},
+		{"const a = 1; a = 2", errors.New("cannot assign to constant: a")},
+		{"const a = 1; a += 1", errors.New("cannot assign to constant: a")},
+		{"const a = 1; let f = fn() { a = 2 }; f()", errors.New("cannot assign to constant: a")},
+		{"let a = 1; const a = 2; a = 3", errors.New("cannot assign to constant: a")},
+		{`let a = 1; a += "b"`, errors.New("type mismatch: INTEGER + STRING")},
+		{"let a = 1; a /= 0", errors.New("division by zero")},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		if err, ok := tt.expected.(error); ok {
+			testErrorObject(t, obj, err.Error())
+			continue
+		}
+		testObject(t, obj, tt.expected)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 10) { i += 1 }; i", 10},
+		{"let i = 0; while (false) { i += 1 }; i", 0},
+		{"while (false) { 1 }", nil},
+		{"let i = 0; while (true) { i += 1; if (i == 5) { break } }; i", 5},
+		{"let i = 0; let n = 0; while (i < 10) { i += 1; if (i % 2 == 0) { continue } n += i }; n", 25},
+		// Arrays, strings, hashes and ranges.
+		{"let s = 0; for (x in [1, 2, 3]) { s += x }; s", 6},
+		{"let s = 0; for (x in []) { s += x }; s", 0},
+		{`let s = ""; for (c in "héllo") { s = c + s }; s`, "olléh"},
+		{`let s = ""; for (k in {"b": 2, "a": 1, "c": 3}) { s += k }; s`, "abc"},
+		{`let s = []; for (k in {"a": 1, 2: 2, true: 3, 1.5: 4}) { s = push(s, k) }; s`,
+			[]interface{}{true, 1.5, 2, "a"}},
+		{"let s = 0; for (i in range(5)) { s += i }; s", 10},
+		{"let s = []; for (i in range(10, 0, -3)) { s = push(s, i) }; s", []interface{}{10, 7, 4, 1}},
+		{"for (x in [1, 2]) { x }", nil},
+		// The variable, and the names bound in the body, are only bound
+		// in the loop.
+		{"let x = 5; for (x in [1, 2]) { }; x", 5},
+		{"let s = 0; for (x in [1, 2]) { let y = x; s += y }; s", 3},
+		{"let f = fn() { let x = 5; for (x in [1, 2]) { }; x }; f()", 5},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { break } s += x }; s", 3},
+		{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { continue } s += x }; s", 7},
+		// Break and continue apply to the innermost loop.
+		{`let s = []; for (a in range(3)) { for (b in range(3)) { if (b > a) { break } s = push(s, 10 * a + b) } }; s`,
+			[]interface{}{0, 10, 11, 20, 21, 22}},
+		{"let n = 0; while (n < 3) { for (x in [1]) { continue }; n += 1 }; n", 3},
+		// Return statements leave the function.
+		{"let f = fn(xs) { for (x in xs) { if (x > 2) { return x } }; -1 }; f([1, 5, 3])", 5},
+		{"let f = fn(xs) { for (x in xs) { if (x > 2) { return x } }; -1 }; f([1])", -1},
+		{"let f = fn() { while (true) { return 1 } }; f()", 1},
+		{"let f = fn() { let i = 0; while (true) { i += 1; if (i == 3) { break } }; i }; f()", 3},
+		// Errors.
+		{"for (x in 5) { }", errors.New("not iterable: INTEGER")},
+		{"while (y) { }", errors.New("identifier not found: y")},
+		{"for (x in [1]) { x + true }", errors.New("type mismatch: INTEGER + BOOLEAN")},
+		{"for (x in [1, 2, 3]) { }; x", errors.New("identifier not found: x")},
+		{"for (x in [1]) { let y = x }; y", errors.New("identifier not found: y")},
+		{"const c = 1; for (c in [5, 6]) { }; c = 9; c", errors.New("cannot assign to constant: c")},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		if err, ok := tt.expected.(error); ok {
+			testErrorObject(t, obj, err.Error())
+			continue
+		}
+		testObject(t, obj, tt.expected)
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		// Literal patterns.
+		{`match (2) { 1 => "a", 2 => "b" }`, "b"},
+		{"match (-3) { 3 => 1, -3 => 2 }", 2},
+		{"match (2.0) { 2 => 1, _ => 2 }", 1},
+		{"match (-1.5) { -1.5 => 1, _ => 2 }", 1},
+		{"match (100000000000000000000) { 100000000000000000000 => 1 }", 1},
+		{`match ("x") { "y" => 1, "x" => 2 }`, 2},
+		{"match (false) { true => 1, false => 2 }", 2},
+		{`match ("1") { 1 => 1, _ => 2 }`, 2},
+		{"match (1) { true => 1, _ => 2 }", 2},
+		// Bindings and wildcards.
+		{"match (5) { x => x * 2 }", 10},
+		{"match (5) { _ => 1 }", 1},
+		{"let x = 1; match (2) { x => x }; x", 1},
+		{"let x = 1; match (2) { y => x = y }; x", 2},
+		{"let f = match (2) { x => fn() { x } }; f()", 2},
+		// Array patterns.
+		{"match ([]) { [] => 1, _ => 2 }", 1},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b }", 3},
+		{"match ([1, [2, 3]]) { [a, [b, c]] => a * b * c }", 6},
+		{"match ([1, 2, 3]) { [h, ...t] => t }", []interface{}{2, 3}},
+		{"match ([1]) { [h, ...t] => t }", []interface{}{}},
+		{"match ([]) { [h, ...t] => 1, _ => 2 }", 2},
+		{"match ([1, 2, 3]) { [a, ..._] => a }", 1},
+		{`match ("ab") { [a, b] => 1, _ => 2 }`, 2},
+		// Hash patterns.
+		{`match ({"a": 1, "b": 2}) { {"a": x} => x }`, 1},
+		{`match ({"a": 1}) { {"b": x} => x, {} => 2 }`, 2},
+		{`match ({1: [2], true: "t"}) { {1: [x], true: y} => y + str(x) }`, "t2"},
+		{"match ([1]) { {} => 1, _ => 2 }", 2},
+		// Guards.
+		{"match (5) { x if x > 10 => 1, x if x > 1 => 2, _ => 3 }", 2},
+		{"match ([1, 2]) { [a, b] if a > b => a, [a, b] => b }", 2},
+		// Errors.
+		{"match (3) { 1 => 1, 2 => 2 }", errors.New("no pattern matched 3")},
+		{`match ([1, "a"]) { [] => 1 }`, errors.New(`no pattern matched [1, "a"]`)},
+		{"match (y) { _ => 1 }", errors.New("identifier not found: y")},
+		{"match (1) { x if x + true => 1 }", errors.New("type mismatch: INTEGER + BOOLEAN")},
+		{"match (1) { x => x + true }", errors.New("type mismatch: INTEGER + BOOLEAN")},
+		{"match (5) { x => x }; x", errors.New("identifier not found: x")},
+		{"match (2) { y if false => 1, _ => 0 }; y", errors.New("identifier not found: y")},
+		{"const c = 1; match (2) { c => c }; c = 3", errors.New("cannot assign to constant: c")},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		if err, ok := tt.expected.(error); ok {
+			testErrorObject(t, obj, err.Error())
+			continue
+		}
+		testObject(t, obj, tt.expected)
+	}
+}
+
 func TestFunctionApplication(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -231,7 +597,144 @@ func TestArrayIndexExpression(t *testing.T) {
 		{"let arr = [1, 2, 3]; arr[2]", 3},
 		{"let i = 1; let arr = [1, 2, 3]; arr[0]; arr[i];", 2},
 		{"[1, 2, 3][3]", nil},
-		{"[1, 2, 3][-1]", nil},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][-4]", nil},
+		{"[1, 2, 3][100000000000000000000]", nil},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		testObject(t, obj, tt.expected)
+	}
+}
+
+func TestSliceExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3, 4][1:3]", []interface{}{2, 3}},
+		{"[1, 2, 3, 4][:2]", []interface{}{1, 2}},
+		{"[1, 2, 3, 4][2:]", []interface{}{3, 4}},
+		{"[1, 2, 3, 4][:]", []interface{}{1, 2, 3, 4}},
+		{"[1, 2, 3, 4][:-1]", []interface{}{1, 2, 3}},
+		{"[1, 2, 3, 4][-2:]", []interface{}{3, 4}},
+		{"[1, 2, 3, 4][1:-1]", []interface{}{2, 3}},
+		{"[1, 2, 3, 4][3:1]", []interface{}{}},
+		{"[1, 2, 3, 4][-10:10]", []interface{}{1, 2, 3, 4}},
+		{"[1, 2][5:]", []interface{}{}},
+		{"[1, 2][:100000000000000000000]", []interface{}{1, 2}},
+		{"[][:]", []interface{}{}},
+		{"let xs = [1, 2, 3]; let i = 1; xs[i:i + 1]", []interface{}{2}},
+		{"let xs = [1, 2]; let ys = xs[:]; ys == xs", true},
+		{"[1, 2, 3][fn() {}():2]", []interface{}{1, 2}},
+		// Strings are sliced by character.
+		{`"héllo"[1:3]`, "él"},
+		{`"héllo"[2:]`, "llo"},
+		{`"héllo"[:-1]`, "héll"},
+		{`"abc"[5:]`, ""},
+		// Errors.
+		{"5[1:]", errors.New("slice operator not supported: INTEGER")},
+		{`{}[1:]`, errors.New("slice operator not supported: HASH_OBJ")},
+		{`[1][1.5:]`, errors.New("slice bound must be an integer, got FLOAT")},
+		{`[1][:"a"]`, errors.New("slice bound must be an integer, got STRING")},
+		{`[1][:y]`, errors.New("identifier not found: y")},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		if err, ok := tt.expected.(error); ok {
+			testErrorObject(t, obj, err.Error())
+			continue
+		}
+		testObject(t, obj, tt.expected)
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3][2]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][3]", errors.New("index out of range: 3 with length 3")},
+		{"[1, 2, 3][-4]", errors.New("index out of range: -4 with length 3")},
+		{`"héllo"[5]`, errors.New("index out of range: 5 with length 5")},
+		{"[][100000000000000000000]", errors.New("index out of range: 100000000000000000000 with length 0")},
+		{`{"a": 1}["b"]`, nil},
+		{"[1, 2, 3][1:3]", []interface{}{2, 3}},
+		{"[1, 2, 3][-3:]", []interface{}{1, 2, 3}},
+		{"[1, 2, 3][3:]", []interface{}{}},
+		{"[1, 2, 3][:4]", errors.New("slice bounds out of range: [:4] with length 3")},
+		{"[1, 2, 3][-4:]", errors.New("slice bounds out of range: [-4:] with length 3")},
+		{"[1, 2, 3][2:1]", errors.New("slice bounds out of range: [2:1] with length 3")},
+		{`"abc"[1:5]`, errors.New("slice bounds out of range: [1:5] with length 3")},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+		e := New()
+		e.SetStrict(true)
+		obj := e.Eval(object.NewEnvironment(), program)
+
+		if err, ok := tt.expected.(error); ok {
+			testErrorObject(t, obj, err.Error())
+			continue
+		}
+		testObject(t, obj, tt.expected)
+	}
+}
+
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"plain"`, "plain"},
+		{`"total: ${1 + 2}"`, "total: 3"},
+		{`let xs = [1, 2]; "${len(xs)} items: ${xs}"`, "2 items: [1, 2]"},
+		{`let name = "monkey"; "hi, ${name}!"`, "hi, monkey!"},
+		{`"${["a"]} ${ {"k": 1.5}["k"] } ${true} ${fn() {}()}"`, `["a"] 1.5 true nil`},
+		{`"${"nested ${1}"}"`, "nested 1"},
+		{`"\${1}"`, "${1}"},
+		{`"${1 + true}"`, errors.New("type mismatch: INTEGER + BOOLEAN")},
+		{`str(1)`, "1"},
+		{`str("a")`, "a"},
+		{`str([1, "a"])`, `[1, "a"]`},
+		{`str(100000000000000000000)`, "100000000000000000000"},
+		{`str()`, errors.New("wrong number of arguments. want 1, got 0")},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		if err, ok := tt.expected.(error); ok {
+			testErrorObject(t, obj, err.Error())
+			continue
+		}
+		testObject(t, obj, tt.expected)
+	}
+}
+
+func TestStringIndexExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`"abc"[0]`, "a"},
+		{`"abc"[2]`, "c"},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[2]`, "l"},
+		{`"日本語"[2]`, "語"},
+		{`"\u00e9\t"[1]`, "\t"},
+		{`"abc"[3]`, nil},
+		{`"abc"[-1]`, "c"},
+		{`"héllo"[-4]`, "é"},
+		{`"abc"[-4]`, nil},
+		{`""[0]`, nil},
 	}
 
 	for _, tt := range tests {
@@ -296,6 +799,23 @@ func TestHashIndexExpression(t *testing.T) {
 		{`{5: 5}[5]`, 5},
 		{`{true: true}[true]`, true},
 		{`{false: false}[false]`, false},
+		{`{[1, 2]: "a"}[[1, 2]]`, "a"},
+		{`{[1, 2]: "a"}[[2, 1]]`, nil},
+		{`{[1]: "a"}[[1.0]]`, "a"},
+		{`{[]: "a"}[[]]`, "a"},
+		{`{[[1], "b"]: "a"}[[[1], "b"]]`, "a"},
+		{`{{"x": 1, "y": [2]}: "a"}[{"y": [2], "x": 1}]`, "a"},
+		{`{{"x": 1}: "a"}[{"x": 2}]`, nil},
+		{`{{}: "a"}[{}]`, "a"},
+		{`{{}: "a"}[[]]`, nil},
+		{`{fn() { }(): "a"}[fn() { }()]`, "a"},
+		{`let f = fn() { 1 }; {f: "a"}[f]`, "a"},
+		{`{fn() { 1 }: "a"}[fn() { 1 }]`, nil},
+		{`{len: "a"}[len]`, "a"},
+		{`let x = 1; let y = 2; let grid = {[x, y]: "cell"}; grid[[1, 2]]`, "cell"},
+		{`let k = [1, 2]; let h = {k: 1}; let longer = push(k, 3); [h[k], h[longer]]`, []interface{}{1, nil}},
+		{`len({[1, 2]: 1, [1.0, 2]: 2})`, 1},
+		{`keys({[2]: 1, [1, 5]: 2, [1]: 3, "a": 4, {}: 5})`, []interface{}{"a", []interface{}{1}, []interface{}{1, 5}, []interface{}{2}, map[interface{}]interface{}{}}},
 	}
 
 	for _, tt := range tests {
@@ -312,6 +832,9 @@ func TestBuiltinFunction(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("héllo")`, 5},
+		{`len("日本語")`, 3},
+		{`len("a\nb")`, 3},
 		// Arrays.
 		{`len([1, 2, 3])`, 3},
 		{`len([1, 2])`, 2},
@@ -333,6 +856,124 @@ func TestBuiltinFunction(t *testing.T) {
 		{`init([])`, []interface{}{}},
 		{`push([], 1)`, []interface{}{1}},
 		{`push([1], 2)`, []interface{}{1, 2}},
+		// Ranges.
+		{`len(range(5))`, 5},
+		{`len(range(2, 5))`, 3},
+		{`len(range(0, 10, 3))`, 4},
+		{`len(range(5, 0))`, 0},
+		{`len(range(5, 0, -1))`, 5},
+		{`len(range(-9223372036854775808, 9223372036854775807))`, 9223372036854775807},
+		// Array functions.
+		{`map([1, 2, 3], fn(x) { x * 2 })`, []interface{}{2, 4, 6}},
+		{`map([], fn(x) { x * 2 })`, []interface{}{}},
+		{`map(range(3), fn(x) { x * x })`, []interface{}{0, 1, 4}},
+		{`map([1, 2], fn(x) { if (x > 1) { return "b" } "a" })`, []interface{}{"a", "b"}},
+		{`map(["a"], len)`, []interface{}{1}},
+		{`filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })`, []interface{}{2, 4}},
+		{`filter(range(5), fn(x) { x > 9 })`, []interface{}{}},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x })`, 6},
+		{`reduce([1, 2, 3], fn(acc, x) { acc + x }, 10)`, 16},
+		{`reduce([], fn(acc, x) { acc + x }, 10)`, 10},
+		{`reduce(["a", "b"], fn(acc, x) { acc + x }, "")`, "ab"},
+		{`reduce([2], fn(acc, x) { acc + x })`, 2},
+		{`sort([3, 1.5, 2])`, []interface{}{1.5, 2, 3}},
+		{`sort(["b", "c", "a"])`, []interface{}{"a", "b", "c"}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []interface{}{3, 2, 1}},
+		{`sort([[2, "a"], [1, "b"], [2, "c"], [1, "d"]], fn(a, b) { a[0] < b[0] })`,
+			[]interface{}{[]interface{}{1, "b"}, []interface{}{1, "d"}, []interface{}{2, "a"}, []interface{}{2, "c"}}},
+		{`sort([])`, []interface{}{}},
+		{`let xs = [2, 1]; sort(xs); xs`, []interface{}{2, 1}},
+		{`slice([1, 2, 3, 4], 1, 3)`, []interface{}{2, 3}},
+		{`slice([1, 2, 3, 4], 2)`, []interface{}{3, 4}},
+		{`slice([1, 2, 3, 4], -3, -1)`, []interface{}{2, 3}},
+		{`slice([1, 2, 3, 4], -10, 10)`, []interface{}{1, 2, 3, 4}},
+		{`slice([1, 2, 3, 4], 3, 1)`, []interface{}{}},
+		{`slice(range(10), 8)`, []interface{}{8, 9}},
+		{`concat([1], [2, 3], range(2))`, []interface{}{1, 2, 3, 0, 1}},
+		{`concat()`, []interface{}{}},
+		{`reverse([1, 2, 3])`, []interface{}{3, 2, 1}},
+		{`reverse(range(3))`, []interface{}{2, 1, 0}},
+		{`reverse([])`, []interface{}{}},
+		{`contains([1, "a", true], "a")`, true},
+		{`contains([1, 2], 2.0)`, true},
+		{`contains([1, 2], "1")`, false},
+		{`contains(range(0, 10, 2), 4)`, true},
+		{`index_of([1, 2, 3, 2], 2)`, 1},
+		{`index_of([1, 2, 3], 4)`, -1},
+		{`zip([1, 2, 3], ["a", "b"])`, []interface{}{[]interface{}{1, "a"}, []interface{}{2, "b"}}},
+		{`zip([], [1])`, []interface{}{}},
+		{`flatten([[1, 2], 3, [], [[4]]])`, []interface{}{1, 2, 3, []interface{}{4}}},
+		// String functions.
+		{`split(" a b  c ")`, []interface{}{"a", "b", "c"}},
+		{`split("a,b,,c", ",")`, []interface{}{"a", "b", "", "c"}},
+		{`split("héllo", "")`, []interface{}{"h", "é", "l", "l", "o"}},
+		{`join(["a", "b"], ", ")`, "a, b"},
+		{`join([1, "a", true])`, "1atrue"},
+		{`join(range(3), "-")`, "0-1-2"},
+		{`join([], ",")`, ""},
+		{`trim(" 	 a b 
+")`, "a b"},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("ÀB")`, "àb"},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`replace("abc", "x", "y")`, "abc"},
+		{`contains("héllo", "llo")`, true},
+		{`contains("héllo", "x")`, false},
+		{`contains("", "")`, true},
+		{`starts_with("hello", "he")`, true},
+		{`starts_with("hello", "lo")`, false},
+		{`ends_with("hello", "lo")`, true},
+		{`ends_with("", "a")`, false},
+		{`index_of("héllo", "l")`, 2},
+		{`index_of("héllo", "x")`, -1},
+		{`index_of("abc", "")`, 0},
+		{`substr("héllo", 1)`, "éllo"},
+		{`substr("héllo", 1, 2)`, "él"},
+		{`substr("héllo", -3, 2)`, "ll"},
+		{`substr("abc", 5)`, ""},
+		{`substr("abc", -10, 1)`, "a"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", 0)`, ""},
+		{`chars("héj")`, []interface{}{"h", "é", "j"}},
+		{`chars("")`, []interface{}{}},
+		{`format("%s is %d", "x", 42)`, "x is 42"},
+		{`format("%.2f|%5s|%-3d|%x", 3.14159, "hi", 7, 255)`, "3.14|   hi|7  |ff"},
+		{`format("%s %v", "a", "a")`, `a "a"`},
+		{`format("%v", [1, "b"])`, `[1, "b"]`},
+		{`format("%d%%", 100000000000000000000)`, "100000000000000000000%"},
+		{`format("%08.3f", 2)`, "0002.000"},
+		{`format("none")`, "none"},
+		{`str(1.5)`, "1.5"},
+		{`parse_int("42")`, 42},
+		{`parse_int(" -7 ")`, -7},
+		{`parse_int("ff", 16)`, 255},
+		{`parse_int("101", 2)`, 5},
+		{`parse_int("100000000000000000000")`, bigInt("100000000000000000000")},
+		{`parse_int("4.2")`, nil},
+		{`parse_int("")`, nil},
+		// Hashes.
+		{`len({})`, 0},
+		{`len({"a": 1, "b": 2})`, 2},
+		{`keys({})`, []interface{}{}},
+		{`keys({"b": 1, "a": 2, 3: 3, true: 4})`, []interface{}{true, 3, "a", "b"}},
+		{`values({"b": 1, "a": 2, 3: 3, true: 4})`, []interface{}{4, 3, 2, 1}},
+		{`entries({"b": 1, "a": 2})`, []interface{}{[]interface{}{"a", 2}, []interface{}{"b", 1}}},
+		{`has({"a": 1}, "a")`, true},
+		{`has({"a": 1}, "b")`, false},
+		{`has({1: 1}, 1.0)`, true},
+		{`get({"a": 1}, "a")`, 1},
+		{`get({"a": 1}, "b")`, nil},
+		{`get({"a": 1}, "b", 2)`, 2},
+		{`get({"a": 1}, "a", 2)`, 1},
+		{`set({"a": 1}, "b", 2)`, map[interface{}]interface{}{"a": 1, "b": 2}},
+		{`set({"a": 1}, "a", 2)`, map[interface{}]interface{}{"a": 2}},
+		{`delete({"a": 1, "b": 2}, "a")`, map[interface{}]interface{}{"b": 2}},
+		{`delete({"a": 1}, "b")`, map[interface{}]interface{}{"a": 1}},
+		{`merge({"a": 1, "b": 2}, {"b": 3, 4: 5})`, map[interface{}]interface{}{"a": 1, "b": 3, 4: 5}},
+		{`merge({}, {})`, map[interface{}]interface{}{}},
+		// The hash builtins leave their arguments unchanged.
+		{`let h = {"a": 1}; set(h, "b", 2); delete(h, "a"); merge(h, {"a": 2}); h`,
+			map[interface{}]interface{}{"a": 1}},
 	}
 
 	for _, tt := range tests {
@@ -358,6 +999,10 @@ func TestErrorHandling(t *testing.T) {
 			"-true",
 			"unknown operator: -BOOLEAN",
 		},
+		{
+			`1 < "1"`,
+			"type mismatch: INTEGER < STRING",
+		},
 		{
 			"true + false",
 			"unknown operator: BOOLEAN + BOOLEAN",
@@ -388,8 +1033,16 @@ func TestErrorHandling(t *testing.T) {
 		},
 		// Arrays and Hashes.
 		{
-			`{"foo": "bar"}[fn(x) { x }]`,
-			"unusable as hash key: FUNCTION_OBJ",
+			`{"foo": "bar"}[range(3)]`,
+			"unusable as hash key: RANGE",
+		},
+		{
+			`{[1, range(3)]: 1}`,
+			"unusable as hash key: ARRAY_OBJ",
+		},
+		{
+			`{{"a": range(3)}: 1}`,
+			"unusable as hash key: HASH_OBJ",
 		},
 		// Builtin.
 		{
@@ -400,6 +1053,192 @@ func TestErrorHandling(t *testing.T) {
 			`len("one", "two")`,
 			"wrong number of arguments. want 1, got 2",
 		},
+		{
+			`range()`,
+			"wrong number of arguments. want 1 to 3, got 0",
+		},
+		{
+			`range("a")`,
+			"argument to `range` not supported, got STRING",
+		},
+		{
+			`range(0, 10, 0)`,
+			"range step cannot be zero",
+		},
+		{
+			`range(100000000000000000000)`,
+			"range bound out of range: 100000000000000000000",
+		},
+		{
+			`map(1, fn(x) { x })`,
+			"argument to `map` not supported, got INTEGER",
+		},
+		{
+			`map([1], 1)`,
+			"not a function: INTEGER",
+		},
+		{
+			`map([1], fn(x, y) { x })`,
+			"argument mismatch: got 1, want 2",
+		},
+		{
+			`map([1, 2], fn(x) { x + true })`,
+			"type mismatch: INTEGER + BOOLEAN",
+		},
+		{
+			`let n = 0; filter([1, 2, 3], fn(x) { n += 1; if (x == 2) { x + "a" } else { true } }); n`,
+			"type mismatch: INTEGER + STRING",
+		},
+		{
+			`reduce([], fn(acc, x) { acc + x })`,
+			"reduce of empty array with no initial value",
+		},
+		{
+			`sort([1, "a"])`,
+			"type mismatch: STRING < INTEGER",
+		},
+		{
+			`sort([2, 1], fn(a, b) { a < b < c })`,
+			"identifier not found: c",
+		},
+		{
+			`slice([1], "a")`,
+			"argument to `slice` not supported, got STRING",
+		},
+		{
+			`concat([1], 2)`,
+			"argument to `concat` not supported, got INTEGER",
+		},
+		{
+			`zip([1])`,
+			"wrong number of arguments. want 2, got 1",
+		},
+		{
+			`split(1)`,
+			"argument to `split` not supported, got INTEGER",
+		},
+		{
+			`split("a", 1)`,
+			"argument to `split` not supported, got INTEGER",
+		},
+		{
+			`join("a")`,
+			"argument to `join` not supported, got STRING",
+		},
+		{
+			`upper("a", "b")`,
+			"wrong number of arguments. want 1, got 2",
+		},
+		{
+			`contains("abc", 1)`,
+			"argument to `contains` not supported, got INTEGER",
+		},
+		{
+			`substr("abc", 1, -1)`,
+			"substr length cannot be negative",
+		},
+		{
+			`repeat("a", -1)`,
+			"repeat count cannot be negative",
+		},
+		{
+			`format()`,
+			"wrong number of arguments. want at least 1, got 0",
+		},
+		{
+			`format("%d", "a")`,
+			"format %d not supported, got STRING",
+		},
+		{
+			`format("%d %d", 1)`,
+			`not enough arguments for format "%d %d", got 1`,
+		},
+		{
+			`format("%d", 1, 2)`,
+			`too many arguments for format "%d". want 1, got 2`,
+		},
+		{
+			`format("%y", 1)`,
+			"unknown format verb %y",
+		},
+		{
+			`format("100%")`,
+			`format "100%" ends in an incomplete verb`,
+		},
+		{
+			`parse_int("1", 40)`,
+			"invalid base 40",
+		},
+		{
+			`[1] < ["a"]`,
+			"type mismatch: INTEGER < STRING",
+		},
+		{
+			"[1] + [2]",
+			"unknown operator: ARRAY_OBJ + ARRAY_OBJ",
+		},
+		{
+			"{} < {}",
+			"unknown operator: HASH_OBJ < HASH_OBJ",
+		},
+		{
+			`1 < "a"`,
+			"type mismatch: INTEGER < STRING",
+		},
+		{
+			`keys([1])`,
+			"argument to `keys` not supported, got ARRAY_OBJ",
+		},
+		{
+			`get({})`,
+			"wrong number of arguments. want 2 to 3, got 1",
+		},
+		{
+			`set({}, [range(1)], 1)`,
+			"unusable as hash key: ARRAY_OBJ",
+		},
+		{
+			`has({}, range(1))`,
+			"unusable as hash key: RANGE",
+		},
+		{
+			`merge({}, 1)`,
+			"argument to `merge` not supported, got INTEGER",
+		},
+		// Division by zero.
+		{
+			"1 / 0",
+			"division by zero",
+		},
+		{
+			"5 % 0",
+			"modulo by zero",
+		},
+		{
+			"100000000000000000000 / 0",
+			"division by zero",
+		},
+		{
+			"100000000000000000000 % (1 - 1)",
+			"modulo by zero",
+		},
+		{
+			"1.5 / 0",
+			"division by zero",
+		},
+		{
+			"1 % 0.0",
+			"modulo by zero",
+		},
+		// Empty blocks.
+		{
+			"fn() { }() + 1",
+			"type mismatch: NIL + INTEGER",
+		},
+		{
+			"if (true) { }(1)",
+			"not a function: NIL",
+		},
 	}
 
 	for _, tt := range tests {
@@ -408,8 +1247,494 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"foobar", "1:1"},
+		{"let x = 1;\nlet y = x + true;", "2:9"},
+		{"let f = fn(x) {\n  x + y\n};\nf(1)", "2:7"},
+		{"len(1, 2)", "1:1"},
+		{"fn(x) { x }(1, 2)", "1:1"},
+		{"if (true) { [1][-true] }", "1:17"},
+		{"let x = 0;\n1 + 10 / x", "2:5"},
+		{"let f = fn(x) {\n  x % 0\n};\nf(1)", "2:3"},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		errobj, ok := obj.(*object.Error)
+		if !ok {
+			castError(t, obj, "*object.Error")
+			continue
+		}
+		if errobj.Pos.String() != tt.expected {
+			t.Errorf("error position of %q is %s, want %s",
+				tt.input, errobj.Pos, tt.expected)
+		}
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	input := `let inner = fn(x) {
+	x + missing
+}
+let outer = fn(x) {
+	let y = 1;
+	inner(x) + y
+}
+outer(1)`
+
+	l := lexer.NewFile("test.monkey", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	obj := Eval(object.NewEnvironment(), program)
+
+	errobj, ok := obj.(*object.Error)
+	if !ok {
+		castError(t, obj, "*object.Error")
+		t.FailNow()
+	}
+
+	expected := `ERROR: identifier not found: missing
+
+inner(...)
+	test.monkey:2:6
+outer(...)
+	test.monkey:6:2
+main()
+	test.monkey:8:1
+`
+	if errobj.StackTrace() != expected {
+		t.Errorf("errobj.StackTrace() is\n%s\nwant\n%s", errobj.StackTrace(), expected)
+	}
+}
+
+func TestCallbackStackTrace(t *testing.T) {
+	input := `let f = fn(xs) {
+	map(xs, fn(x) { x + missing })
+}
+f([1])`
+
+	l := lexer.NewFile("test.monkey", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	obj := Eval(object.NewEnvironment(), program)
+
+	errobj, ok := obj.(*object.Error)
+	if !ok {
+		castError(t, obj, "*object.Error")
+		t.FailNow()
+	}
+
+	// Functions called back by builtins are called from the builtin call.
+	expected := `ERROR: identifier not found: missing
+
+fn(...)
+	test.monkey:2:22
+f(...)
+	test.monkey:2:2
+main()
+	test.monkey:4:1
+`
+	if errobj.StackTrace() != expected {
+		t.Errorf("errobj.StackTrace() is\n%s\nwant\n%s", errobj.StackTrace(), expected)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// Without tail calls these would need far more stack than this.
+	defer debug.SetMaxStack(debug.SetMaxStack(8 << 20))
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{
+			"let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(100000, 0)",
+			100000,
+		},
+		{
+			"let count = fn(n, acc) { match (n) { 0 => acc, _ => count(n - 1, acc + 1) } }; count(100000, 0)",
+			100000,
+		},
+		{
+			"let count = fn(n) { if (n == 0) { return 0; } return count(n - 1); }; count(100000)",
+			0,
+		},
+		{
+			"let count = fn(n) { if (n > 0) { return count(n - 1); } len(\"done\") }; count(100000)",
+			4,
+		},
+		{
+			`let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+			let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+			even(100001)`,
+			false,
+		},
+		{
+			"let f = fn(n) { if (n == 0) { len(1) } else { f(n - 1) } }; f(100000)",
+			"argument to `len` not supported, got INTEGER",
+		},
+	}
+
+	for _, tt := range tests {
+		obj := testEval(tt.input)
+		if msg, ok := tt.expected.(string); ok {
+			testErrorObject(t, obj, msg)
+		} else {
+			testObject(t, obj, tt.expected)
+		}
+	}
+}
+
+func TestTailCallStackTrace(t *testing.T) {
+	input := `let g = fn(x) { x(1) }
+let f = fn(x) { g(x) }
+f(2)`
+
+	l := lexer.NewFile("test.monkey", input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	obj := Eval(object.NewEnvironment(), program)
+
+	errobj, ok := obj.(*object.Error)
+	if !ok {
+		castError(t, obj, "*object.Error")
+		t.FailNow()
+	}
+
+	// The call to g replaced the frame of f.
+	expected := `ERROR: not a function: INTEGER
+
+g(...)
+	test.monkey:1:17
+main()
+	test.monkey:3:1
+`
+	if errobj.StackTrace() != expected {
+		t.Errorf("errobj.StackTrace() is\n%s\nwant\n%s", errobj.StackTrace(), expected)
+	}
+}
+
+func TestDeepStackTrace(t *testing.T) {
+	l := lexer.NewFile("test.monkey", "let f = fn(n) { 1 + f(n + 1) }; f(0)")
+	p := parser.New(l)
+	program := p.ParseProgram()
+	obj := Eval(object.NewEnvironment(), program)
+
+	errobj, ok := obj.(*object.Error)
+	if !ok {
+		castError(t, obj, "*object.Error")
+		t.FailNow()
+	}
+	if len(errobj.Stack) != DefaultMaxDepth+1 {
+		t.Fatalf("len(errobj.Stack) is %d, want %d", len(errobj.Stack), DefaultMaxDepth+1)
+	}
+
+	// Only the frames at either end are printed.
+	trace := errobj.StackTrace()
+	if lines := strings.Count(trace, "\n"); lines != 203 {
+		t.Errorf("stack trace has %d lines, want 203", lines)
+	}
+	if !strings.Contains(trace, "\n...additional frames elided...\nf(...)\n") {
+		t.Errorf("stack trace doesn't elide frames:\n%s", trace)
+	}
+	if !strings.HasSuffix(trace, "main()\n\ttest.monkey:1:33\n") {
+		t.Errorf("stack trace doesn't end in main:\n%s", trace)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		input    string
+		limits   Limits
+		expected object.ErrorKind
+	}{
+		{"let f = fn() { f() }; f()", Limits{MaxSteps: 1000}, object.StepLimitError},
+		{"let f = fn(n) { 1 + f(n + 1) }; f(0)", Limits{}, object.DepthLimitError},
+		{"let f = fn(n) { 1 + f(n + 1) }; f(0)", Limits{MaxDepth: 10}, object.DepthLimitError},
+		{"let f = fn(a) { f(push(a, 1)) }; f([])", Limits{MaxAllocs: 100}, object.AllocLimitError},
+		{"reverse(range(0, 1000000000))", Limits{MaxAllocs: 1000}, object.AllocLimitError},
+		{"let xs = [1, 2, 3, 4]; sort(concat(xs, xs, xs, xs))", Limits{MaxAllocs: 20}, object.AllocLimitError},
+		{"map(range(10), fn(x) { x })", Limits{MaxAllocs: 100}, object.RuntimeError},
+		{`repeat("ab", 100000000)`, Limits{MaxAllocs: 1000}, object.AllocLimitError},
+		{`repeat("ab", 10)`, Limits{MaxAllocs: 100}, object.RuntimeError},
+		{`len(chars(repeat("ab", 40)))`, Limits{MaxAllocs: 100}, object.AllocLimitError},
+		{`len(split(repeat("a ", 40)))`, Limits{MaxAllocs: 100}, object.AllocLimitError},
+		{`len(chars(repeat("ab", 10)))`, Limits{MaxAllocs: 100}, object.RuntimeError},
+		{`let s = "ab"; for (i in range(30)) { s = replace(s, "a", "aa") }; len(s)`, Limits{MaxAllocs: 1000}, object.AllocLimitError},
+		{`let s = "ab"; for (i in range(30)) { s = join([s, s]) }; len(s)`, Limits{MaxAllocs: 1000}, object.AllocLimitError},
+		{`for (i in range(200)) { upper("abcdefgh") }`, Limits{MaxAllocs: 1000}, object.AllocLimitError},
+		{`for (i in range(200)) { format("%010d", 1) }`, Limits{MaxAllocs: 2000}, object.AllocLimitError},
+		{"let h = {1: 1, 2: 2, 3: 3, 4: 4, 5: 5}; for (i in range(50)) { keys(h) }", Limits{MaxAllocs: 200}, object.AllocLimitError},
+		{"let h = {1: 1, 2: 2, 3: 3, 4: 4, 5: 5}; for (i in range(50)) { entries(h) }", Limits{MaxAllocs: 200}, object.AllocLimitError},
+		{"let h = {1: 1, 2: 2, 3: 3, 4: 4, 5: 5}; for (i in range(50)) { set(h, 6, 6) }", Limits{MaxAllocs: 200}, object.AllocLimitError},
+		{"let h = {1: 1, 2: 2, 3: 3, 4: 4, 5: 5}; for (i in range(50)) { merge(h, h) }", Limits{MaxAllocs: 200}, object.AllocLimitError},
+		{"let h = {1: 1, 2: 2, 3: 3, 4: 4, 5: 5}; for (i in range(5)) { merge(h, h) }", Limits{MaxAllocs: 200}, object.RuntimeError},
+		{`let s = "ab"; for (i in range(30)) { s = s + s }; len(s)`, Limits{MaxAllocs: 1000}, object.AllocLimitError},
+		{`let s = "ab"; for (i in range(30)) { s += s }; len(s)`, Limits{MaxAllocs: 1000}, object.AllocLimitError},
+		{`let s = "ab"; for (i in range(30)) { s = "${s}${s}" }; len(s)`, Limits{MaxAllocs: 1000}, object.AllocLimitError},
+		{"let n = 3; for (i in range(30)) { n = n * n }; n", Limits{MaxAllocs: 1000}, object.AllocLimitError},
+		{`let s = "ab"; for (i in range(4)) { s = s + s }; len(s)`, Limits{MaxAllocs: 100}, object.RuntimeError},
+		{"let f = fn() { f() }; f()", Limits{Timeout: time.Millisecond}, object.DeadlineError},
+		{"let f = fn(n) { if (n > 0) { 1 + f(n - 1) } else { 0 } }; f(10)", Limits{MaxDepth: 11}, object.RuntimeError},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		obj := NewWithLimits(tt.limits).Eval(object.NewEnvironment(), program)
+		errobj, ok := obj.(*object.Error)
+		if tt.expected == object.RuntimeError {
+			if ok {
+				t.Errorf("%q failed: %s", tt.input, errobj.Message)
+			}
+			continue
+		}
+		if !ok {
+			castError(t, obj, "*object.Error")
+			continue
+		}
+		if errobj.Kind != tt.expected {
+			t.Errorf("%q failed with %s (%s), want %s",
+				tt.input, errobj.Kind, errobj.Message, tt.expected)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	l := lexer.New("let f = fn() { f() }; f()")
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	obj := New().EvalContext(ctx, object.NewEnvironment(), program)
+	errobj, ok := obj.(*object.Error)
+	if !ok {
+		castError(t, obj, "*object.Error")
+		t.FailNow()
+	}
+	if errobj.Kind != object.CanceledError {
+		t.Errorf("errobj.Kind is %s, want %s", errobj.Kind, object.CanceledError)
+	}
+}
+
+type point struct {
+	X, Y   int
+	Label  string `monkey:"label"`
+	hidden bool
+}
+
+// labeled and boxed embed point, so its fields are theirs too.
+type labeled struct {
+	point
+	Name string
+}
+
+type boxed struct {
+	*point
+	Name string
+}
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+func TestRegister(t *testing.T) {
+	b := DefaultBuiltins()
+	registrations := map[string]interface{}{
+		"answer": 42,
+		"add":    func(a, b int64) int64 { return a + b },
+		"join": func(sep string, parts ...string) string {
+			return strings.Join(parts, sep)
+		},
+		"keys": func(m map[string]int) []string {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		},
+		"origin": func() point { return point{Label: "o"} },
+		"norm":   func(p point) int { return p.X*p.X + p.Y*p.Y },
+		"name":   func(l labeled) string { return l.Name + l.Label },
+		"unbox":  func(b boxed) int { return b.X },
+		"check": func(ok bool) error {
+			if !ok {
+				return errors.New("check failed")
+			}
+			return nil
+		},
+		"divide": func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+		"byte":  func(b uint8) uint8 { return b },
+		"same":  func(v interface{}) interface{} { return v },
+		"echo":  func(rt object.Runtime, s string) { fmt.Fprint(rt.Stdout(), s) },
+		"first": func(arr []object.Object) object.Object { return arr[0] },
+		"boom":  func() { panic("boom") },
+	}
+	for name, v := range registrations {
+		if err := b.Register(name, v); err != nil {
+			t.Fatalf("b.Register(%q) returned error: %s", name, err)
+		}
+	}
+
+	if err := b.Register("bad", func() (int, int) { return 0, 0 }); err == nil {
+		t.Errorf("registering a function returning two values succeeded")
+	}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"answer", 42},
+		{"add(1, 2)", 3},
+		{`join("-", "a", "b", "c")`, "a-b-c"},
+		{`join(",")`, ""},
+		{`keys({"b": 1, "a": 2})`, []interface{}{"a", "b"}},
+		{`origin()["label"]`, "o"},
+		{`origin()["X"]`, 0},
+		{`origin()["hidden"]`, nil},
+		{`norm({"X": 3, "Y": 4})`, 25},
+		{`name({"Name": "a", "label": "b"})`, "ab"},
+		{"check(true)", nil},
+		{"divide(7, 2)", 3},
+		{`same([1, "a", true])`, []interface{}{1, "a", true}},
+		{`same({"a": [1]})["a"][0]`, 1},
+		{`same({[1, 2]: "a", 3: "b"})[[1, 2]]`, "a"},
+		{"same(fn(x) { x })(5)", 5},
+		{`first([fn() { 1 }])()`, 1},
+		// Errors.
+		{"add(1)", "wrong number of arguments. want 2, got 1"},
+		{`add(1, "2")`, "argument 2 to `add` not supported: want INTEGER, got STRING"},
+		{`join()`, "wrong number of arguments. want at least 1, got 0"},
+		{`join("", 1)`, "argument 2 to `join` not supported: want STRING, got INTEGER"},
+		{`keys({"a": "b"})`, "argument 1 to `keys` not supported: want INTEGER, got STRING"},
+		{"check(false)", "check failed"},
+		{"divide(1, 0)", "division by zero"},
+		{"byte(256)", "argument 1 to `byte` not supported: 256 overflows uint8"},
+		{`unbox({"X": 1})`, "argument 1 to `unbox` not supported: field X: cannot set embedded pointer to unexported evaluator.point"},
+		{"boom()", "builtin function panicked: boom"},
+		{"first([])", "builtin function panicked: runtime error: index out of range [0] with length 0"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		e := New()
+		e.SetBuiltins(b)
+		obj := e.Eval(object.NewEnvironment(), program)
+
+		switch expected := tt.expected.(type) {
+		case string:
+			if errobj, ok := obj.(*object.Error); ok {
+				if errobj.Message != expected {
+					t.Errorf("%q failed with %q, want %q", tt.input, errobj.Message, expected)
+				}
+				continue
+			}
+			testStringObject(t, obj, expected)
+		case nil:
+			testNilObject(t, obj)
+		default:
+			testObject(t, obj, expected)
+		}
+	}
+
+	var out bytes.Buffer
+	e := New()
+	e.SetBuiltins(b)
+	e.SetStreams(nil, &out, nil)
+	e.Eval(object.NewEnvironment(), parser.New(lexer.New(`echo("hi")`)).ParseProgram())
+	if out.String() != "hi" {
+		t.Errorf("echo wrote %q, want %q", out.String(), "hi")
+	}
+}
+
+func TestFromGo(t *testing.T) {
+	shared := &node{Value: 2}
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "nil"},
+		{true, "true"},
+		{int8(-3), "-3"},
+		{uint16(3), "3"},
+		{float32(1.5), "1.5"},
+		{2.0, "2.0"},
+		{"a", `"a"`},
+		{[2]int{1, 2}, "[1, 2]"},
+		{[]interface{}{1, nil}, "[1, nil]"},
+		{map[int]bool{1: true}, "{1: true}"},
+		{&point{X: 1, Label: "p"}, `{"X": 1, "Y": 0, "label": "p"}`},
+		{(*point)(nil), "nil"},
+		{errors.New("boom"), "ERROR: boom"},
+		{uint64(1 << 63), "9223372036854775808"},
+		{new(big.Int).Lsh(big.NewInt(1), 70), "1180591620717411303424"},
+		{big.NewInt(5), "5"},
+		{labeled{point: point{X: 1, Label: "p"}, Name: "n"}, `{"Name": "n", "X": 1, "Y": 0, "label": "p"}`},
+		{boxed{Name: "n"}, `{"Name": "n"}`},
+		{struct{ A, B *node }{shared, shared}, `{"A": {"Next": nil, "Value": 2}, "B": {"Next": nil, "Value": 2}}`},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.value)
+		if err != nil {
+			t.Errorf("FromGo(%#v) returned error: %s", tt.value, err)
+			continue
+		}
+		if inspectSorted(obj) != tt.expected {
+			t.Errorf("FromGo(%#v) is %s, want %s", tt.value, obj.Inspect(), tt.expected)
+		}
+	}
+
+	if _, err := FromGo(complex(1, 2)); err == nil {
+		t.Errorf("FromGo(complex(1, 2)) succeeded")
+	}
+
+	// Values that contain themselves.
+	cycle := &node{Value: 1}
+	cycle.Next = &node{Value: 2, Next: cycle}
+	m := map[string]interface{}{}
+	m["m"] = m
+	for _, v := range []interface{}{cycle, m} {
+		if _, err := FromGo(v); err == nil {
+			t.Errorf("FromGo of a value containing itself succeeded")
+		}
+	}
+}
+
 // Helper functions for testing.
 
+// inspectSorted is like obj.Inspect, but sorts the pairs of hashes.
+func inspectSorted(obj object.Object) string {
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		return obj.Inspect()
+	}
+	pairs := make([]string, 0, len(hash.Pairs))
+	for _, p := range hash.Pairs {
+		pairs = append(pairs, p.Key.Inspect()+": "+inspectSorted(p.Value))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -453,6 +1778,37 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testBigIntegerObject(t *testing.T, obj object.Object, expected *big.Int) bool {
+	result, ok := obj.(*object.BigInteger)
+	if !ok {
+		castError(t, obj, "*object.BigInteger")
+		return false
+	}
+	if result.Value.Cmp(expected) != 0 {
+		expectedError(t, "result.Value", result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func bigInt(s string) *big.Int {
+	i, _ := new(big.Int).SetString(s, 10)
+	return i
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	floatobj, ok := obj.(*object.Float)
+	if !ok {
+		castError(t, obj, "*object.Float")
+		return false
+	}
+	if floatobj.Value != expected {
+		expectedError(t, "floatobj.Value", floatobj.Value, expected)
+		return false
+	}
+	return true
+}
+
 func testStringObject(t *testing.T, obj object.Object, expected string) bool {
 	strobj, ok := obj.(*object.String)
 	if !ok {
@@ -485,6 +1841,38 @@ func testArrayObject(t *testing.T, obj object.Object, expected []interface{}) bo
 	return true
 }
 
+func testHashObject(t *testing.T, obj object.Object, expected map[interface{}]interface{}) bool {
+	hash, ok := obj.(*object.Hash)
+	if !ok {
+		castError(t, obj, "*object.Hash")
+		return false
+	}
+	if len(hash.Pairs) != len(expected) {
+		t.Errorf("len(hash.Pairs) got %d, want %d",
+			len(hash.Pairs), len(expected))
+		return false
+	}
+	for k, v := range expected {
+		key, err := FromGo(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashed, ok := object.HashKeyOf(key)
+		if !ok {
+			t.Fatalf("unusable as hash key: %s", key.Type())
+		}
+		pair, ok := hash.Pairs[hashed]
+		if !ok {
+			t.Errorf("no pair for key %s in %s", key.Inspect(), hash.Inspect())
+			return false
+		}
+		if !testObject(t, pair.Value, v) {
+			return false
+		}
+	}
+	return true
+}
+
 func testErrorObject(t *testing.T, obj object.Object, expected string) bool {
 	errobj, ok := obj.(*object.Error)
 	if !ok {
@@ -508,10 +1896,16 @@ func testObject(t *testing.T, obj object.Object, expected interface{}) bool {
 		return testIntegerObject(t, obj, v)
 	case int:
 		return testIntegerObject(t, obj, int64(v))
+	case *big.Int:
+		return testBigIntegerObject(t, obj, v)
+	case float64:
+		return testFloatObject(t, obj, v)
 	case string:
 		return testStringObject(t, obj, v)
 	case []interface{}:
 		return testArrayObject(t, obj, v)
+	case map[interface{}]interface{}:
+		return testHashObject(t, obj, v)
 	}
 
 	t.Errorf("Object %T not handled", obj)
@@ -0,0 +1,167 @@
+package evaluator
+
+import (
+	"math/big"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/danielrs/monkey/object"
+)
+
+// Iterator steps through the elements of an array, the characters of a
+// string, the keys of a hash or the integers of a range, as for loops do.
+// It is an object so the virtual machine can keep it on its stack.
+type Iterator struct {
+	next func() (object.Object, bool)
+}
+
+func (it *Iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *Iterator) Inspect() string         { return "iterator" }
+
+// Next returns the next element, or false when there are no more.
+func (it *Iterator) Next() (object.Object, bool) {
+	return it.next()
+}
+
+func iterate(obj object.Object) object.Object {
+	switch obj := obj.(type) {
+	case *object.Array:
+		i := 0
+		return &Iterator{func() (object.Object, bool) {
+			if i >= len(obj.Elements) {
+				return nil, false
+			}
+			i++
+			return obj.Elements[i-1], true
+		}}
+
+	case *object.String:
+		s := obj.Value
+		return &Iterator{func() (object.Object, bool) {
+			if s == "" {
+				return nil, false
+			}
+			_, size := utf8.DecodeRuneInString(s)
+			c := s[:size]
+			s = s[size:]
+			return &object.String{Value: c}, true
+		}}
+
+	case *object.Hash:
+		pairs := sortedPairs(obj)
+		i := 0
+		return &Iterator{func() (object.Object, bool) {
+			if i >= len(pairs) {
+				return nil, false
+			}
+			i++
+			return pairs[i-1].Key, true
+		}}
+
+	case *object.Range:
+		n := obj.Len()
+		i := int64(0)
+		return &Iterator{func() (object.Object, bool) {
+			if i >= n {
+				return nil, false
+			}
+			i++
+			return &object.Integer{Value: obj.At(i - 1)}, true
+		}}
+	}
+
+	return newError("not iterable: %s", obj.Type())
+}
+
+// sortedPairs returns the pairs of a hash sorted by key, so they always
+// come in the same order: nil first, then booleans, numbers, strings,
+// arrays, hashes and functions.
+func sortedPairs(h *object.Hash) []object.HashPair {
+	pairs := make([]object.HashPair, 0, len(h.Pairs))
+	for _, p := range h.Pairs {
+		pairs = append(pairs, p)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		return keyLess(pairs[i].Key, pairs[j].Key)
+	})
+	return pairs
+}
+
+func keyLess(a, b object.Object) bool {
+	if ra, rb := keyRank(a), keyRank(b); ra != rb {
+		return ra < rb
+	}
+
+	switch a := a.(type) {
+	case *object.Nil:
+		return false
+	case *object.Boolean:
+		return !a.Value && b.(*object.Boolean).Value
+	case *object.String:
+		return a.Value < b.(*object.String).Value
+	case *object.Array:
+		bs := b.(*object.Array).Elements
+		for i := 0; i < len(a.Elements) && i < len(bs); i++ {
+			if keyLess(a.Elements[i], bs[i]) {
+				return true
+			}
+			if keyLess(bs[i], a.Elements[i]) {
+				return false
+			}
+		}
+		return len(a.Elements) < len(bs)
+	case *object.Hash:
+		as, bs := sortedPairs(a), sortedPairs(b.(*object.Hash))
+		for i := 0; i < len(as) && i < len(bs); i++ {
+			for _, k := range [][2]object.Object{
+				{as[i].Key, bs[i].Key},
+				{as[i].Value, bs[i].Value},
+			} {
+				if keyLess(k[0], k[1]) {
+					return true
+				}
+				if keyLess(k[1], k[0]) {
+					return false
+				}
+			}
+		}
+		return len(as) < len(bs)
+	case *object.Function, *object.Closure, *object.Builtin:
+		// Functions have no order of their own, so go by identity.
+		return a.(object.Hasher).HashKey().Value < b.(object.Hasher).HashKey().Value
+	}
+
+	// Numbers. NaN goes first, as big floats can't hold it.
+	fa, fb := toFloat(a), toFloat(b)
+	if fa != fa || fb != fb {
+		return fa != fa && fb == fb
+	}
+	return numberToBigFloat(a).Cmp(numberToBigFloat(b)) < 0
+}
+
+func keyRank(key object.Object) int {
+	switch key.(type) {
+	case *object.Nil:
+		return -1
+	case *object.Boolean:
+		return 0
+	case *object.Integer, *object.BigInteger, *object.Float:
+		return 1
+	case *object.String:
+		return 2
+	case *object.Array:
+		return 3
+	case *object.Hash:
+		return 4
+	}
+	return 5
+}
+
+// numberToBigFloat returns the exact value of a number, which must not be
+// NaN.
+func numberToBigFloat(n object.Object) *big.Float {
+	if f, ok := n.(*object.Float); ok {
+		return big.NewFloat(f.Value)
+	}
+	return new(big.Float).SetInt(toBig(n))
+}
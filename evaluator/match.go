@@ -0,0 +1,82 @@
+package evaluator
+
+import (
+	"github.com/danielrs/monkey/ast"
+	"github.com/danielrs/monkey/object"
+)
+
+// match matches value against a pattern, appending the values it binds to
+// values in the order of ast.Bindings.
+func match(p ast.Pattern, value object.Object, values *[]object.Object) bool {
+	switch p := p.(type) {
+	case *ast.BindingPattern:
+		if !p.IsWildcard() {
+			*values = append(*values, value)
+		}
+		return true
+
+	case *ast.LiteralPattern:
+		return equal(literalValue(p.Value), value)
+
+	case *ast.ArrayPattern:
+		arr, ok := value.(*object.Array)
+		if !ok || len(arr.Elements) < len(p.Elements) ||
+			p.Rest == nil && len(arr.Elements) != len(p.Elements) {
+			return false
+		}
+		for i, el := range p.Elements {
+			if !match(el, arr.Elements[i], values) {
+				return false
+			}
+		}
+		if p.Rest != nil && p.Rest.Value != "_" {
+			rest := make([]object.Object, len(arr.Elements)-len(p.Elements))
+			copy(rest, arr.Elements[len(p.Elements):])
+			*values = append(*values, &object.Array{Elements: rest})
+		}
+		return true
+
+	case *ast.HashPattern:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false
+		}
+		for i, k := range p.Keys {
+			key, ok := object.HashKeyOf(literalValue(k))
+			if !ok {
+				return false
+			}
+			pair, ok := hash.Pairs[key]
+			if !ok || !match(p.Values[i], pair.Value, values) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// literalValue returns the value of the literal in a pattern.
+func literalValue(lit ast.Expression) object.Object {
+	switch lit := lit.(type) {
+	case *ast.IntegerLiteral:
+		if lit.Big != nil {
+			return &object.BigInteger{Value: lit.Big}
+		}
+		return &object.Integer{Value: lit.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: lit.Value}
+	case *ast.StringLiteral:
+		return &object.String{Value: lit.Value}
+	case *ast.BooleanLiteral:
+		return nativeBooleanToObject(lit.Value)
+	case *ast.PrefixExpression:
+		return evalPrefixExpression(lit.Operator, literalValue(lit.Right))
+	}
+	return NULL
+}
+
+func noMatch(value object.Object) *object.Error {
+	return newError("no pattern matched %s", value.Inspect())
+}
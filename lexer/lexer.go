@@ -1,44 +1,85 @@
 package lexer
 
 import (
+	"fmt"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/danielrs/monkey/diagnostic"
 	"github.com/danielrs/monkey/token"
 )
 
 type Lexer struct {
+	filename     string
 	input        string
 	position     int
 	readPosition int
 	ch           token.Character
+
+	// line and column of the character at position.
+	line   int
+	column int
+
+	// interps has an entry for every interpolated expression being read,
+	// counting the braces opened in it, so the brace that closes it can be
+	// told apart.
+	interps []int
+
+	errors []*diagnostic.Diagnostic
 }
 
 // Returns a pointer to a new Lexer.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewFile("", input)
+}
+
+// NewFile is like New but records the given filename in the position
+// of every token.
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{filename: filename, input: input, line: 1}
 	l.readChar()
 	return l
 }
 
+// Errors returns the diagnostics reported while reading tokens, in source
+// order.
+func (l *Lexer) Errors() []*diagnostic.Diagnostic {
+	return l.errors
+}
+
 // Gets the next token in the stream.
 func (l *Lexer) NextToken() token.Token {
+	l.skipWhitespace()
+	pos := l.pos()
+	tok := l.nextToken()
+	tok.Pos = pos
+	tok.End = l.pos()
+	return tok
+}
+
+// nextToken reads the token starting at the current character, leaving
+// the lexer on the character right after it.
+func (l *Lexer) nextToken() token.Token {
 	var tok token.Token
 
-	l.skipWhitespace()
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
 			l.readChar()
 			tok = token.Make(token.EQ, token.Literal("=="))
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Make(token.ARROW, token.Literal("=>"))
 		} else {
 			tok = token.Make(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = token.Make(token.PLUS, l.ch)
+		tok = l.makeWithAssign(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = token.Make(token.MINUS, l.ch)
+		tok = l.makeWithAssign(token.MINUS, token.MINUS_ASSIGN)
 	case '*':
-		tok = token.Make(token.ASTERISK, l.ch)
+		tok = l.makeWithAssign(token.ASTERISK, token.ASTERISK_ASSIGN)
 	case '/':
 		if l.peekChar() == '/' {
 			l.readChar()
@@ -47,11 +88,12 @@ func (l *Lexer) NextToken() token.Token {
 				return c == '\n'
 			})
 			tok.Type = token.COMMENT
+			return tok
 		} else {
-			tok = token.Make(token.SLASH, l.ch)
+			tok = l.makeWithAssign(token.SLASH, token.SLASH_ASSIGN)
 		}
 	case '%':
-		tok = token.Make(token.MOD, l.ch)
+		tok = l.makeWithAssign(token.MOD, token.MOD_ASSIGN)
 	case '!':
 		if l.peekChar() == '=' {
 			l.readChar()
@@ -61,9 +103,9 @@ func (l *Lexer) NextToken() token.Token {
 		}
 
 	case '<':
-		tok = token.Make(token.LT, l.ch)
+		tok = l.makeWithAssign(token.LT, token.LT_EQ)
 	case '>':
-		tok = token.Make(token.GT, l.ch)
+		tok = l.makeWithAssign(token.GT, token.GT_EQ)
 
 	case '&':
 		if l.peekChar() == '&' {
@@ -82,14 +124,32 @@ func (l *Lexer) NextToken() token.Token {
 		tok = token.Make(token.SEMICOLON, l.ch)
 	case ':':
 		tok = token.Make(token.COLON, l.ch)
+	case '.':
+		if l.peekChar() == '.' && l.peekCharAt(2) == '.' {
+			l.readChar()
+			l.readChar()
+			tok = token.Make(token.ELLIPSIS, token.Literal("..."))
+		} else {
+			tok = token.Make(token.ILLEGAL, l.ch)
+		}
 
 	case '(':
 		tok = token.Make(token.LPAREN, l.ch)
 	case ')':
 		tok = token.Make(token.RPAREN, l.ch)
 	case '{':
+		if n := len(l.interps); n > 0 {
+			l.interps[n-1]++
+		}
 		tok = token.Make(token.LBRACE, l.ch)
 	case '}':
+		if n := len(l.interps); n > 0 {
+			if l.interps[n-1] == 0 {
+				l.interps = l.interps[:n-1]
+				return l.readString(true)
+			}
+			l.interps[n-1]--
+		}
 		tok = token.Make(token.RBRACE, l.ch)
 	case '[':
 		tok = token.Make(token.LBRACKET, l.ch)
@@ -105,20 +165,11 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.LookupIdent(tok.Literal)
 			return tok
 		} else if l.ch.IsDigit() {
-			tok.Literal = l.readWhile(token.Character.IsDigit)
-			if len(tok.Literal) > 1 {
-				tok.Literal = strings.TrimLeft(tok.Literal, "0")
-			}
-			tok.Type = token.INT
-			return tok
+			return l.readNumber()
 		} else if l.ch.IsChar('"') {
-			l.readChar()
-			tok.Literal = l.readUntil(func(c token.Character) bool {
-				return c == '"'
-			})
-			l.readChar()
-			tok.Type = token.STRING
-			return tok
+			return l.readString(false)
+		} else if l.ch.IsChar('`') {
+			return l.readRawString()
 		} else {
 			tok = token.Make(token.ILLEGAL, l.ch)
 		}
@@ -128,15 +179,217 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
-// readChar reads the next byte in the stream and advances the lexer position.
+// makeWithAssign makes a token of type t from the current character, or of
+// type withAssign if an = follows it, as in += or <=.
+func (l *Lexer) makeWithAssign(t, withAssign token.TokenType) token.Token {
+	if l.peekChar() == '=' {
+		ch := l.ch
+		l.readChar()
+		return token.Make(withAssign, token.Literal(string(ch)+"="))
+	}
+	return token.Make(t, l.ch)
+}
+
+// readNumber reads an integer or a float. Floats have a fractional part,
+// an exponent or both, like 3.14, 1e-9 or 2.5E+3.
+func (l *Lexer) readNumber() token.Token {
+	var tok token.Token
+
+	position := l.position
+	l.readWhile(token.Character.IsDigit)
+	tok.Type = token.INT
+
+	if l.ch == '.' && token.Character(l.peekChar()).IsDigit() {
+		l.readChar()
+		l.readWhile(token.Character.IsDigit)
+		tok.Type = token.FLOAT
+	}
+
+	if l.ch == 'e' || l.ch == 'E' {
+		digit := 1
+		if next := l.peekChar(); next == '+' || next == '-' {
+			digit = 2
+		}
+		if token.Character(l.peekCharAt(digit)).IsDigit() {
+			for i := 0; i < digit; i++ {
+				l.readChar()
+			}
+			l.readWhile(token.Character.IsDigit)
+			tok.Type = token.FLOAT
+		}
+	}
+
+	tok.Literal = l.input[position:l.position]
+	if tok.Type == token.INT && len(tok.Literal) > 1 {
+		tok.Literal = strings.TrimLeft(tok.Literal, "0")
+		if tok.Literal == "" {
+			tok.Literal = "0"
+		}
+	}
+	return tok
+}
+
+// readString reads a string between double quotes, replacing its escape
+// sequences by the characters they stand for. The escapes are the ones of
+// Go string literals, plus \$ for a dollar sign.
+//
+// A string with interpolated expressions is read up to the next ${, and
+// then from the } that closes the expression, which is the case when cont
+// is true.
+func (l *Lexer) readString(cont bool) token.Token {
+	start := l.pos()
+	l.readChar()
+
+	var part, end token.TokenType = token.INTERP_START, token.STRING
+	if cont {
+		part, end = token.INTERP_MID, token.INTERP_END
+	}
+
+	var out strings.Builder
+	for l.ch != '"' {
+		switch {
+		case l.ch == 0 && l.position >= len(l.input):
+			return l.unterminated(start)
+
+		case l.ch == '$' && l.peekChar() == '{':
+			l.readChar()
+			l.readChar()
+			l.interps = append(l.interps, 0)
+			return token.Token{Type: part, Literal: out.String()}
+
+		case l.ch == '\\' && l.peekChar() == '$':
+			l.readChar()
+			l.readChar()
+			out.WriteByte('$')
+
+		case l.ch == '\\':
+			escape := l.pos()
+			value, multibyte, tail, err := strconv.UnquoteChar(l.input[l.position:], '"')
+			if err != nil {
+				// Skips the backslash and the character after it.
+				l.readChar()
+				if l.ch != 0 {
+					l.readChar()
+				}
+				l.errorf(escape, diagnostic.InvalidEscape,
+					"invalid escape sequence %s", l.input[escape.Offset:l.position])
+				continue
+			}
+			for n := len(l.input) - l.position - len(tail); n > 0; n-- {
+				l.readChar()
+			}
+			if multibyte {
+				out.WriteRune(value)
+			} else {
+				out.WriteByte(byte(value))
+			}
+
+		default:
+			out.WriteString(l.input[l.position:l.readPosition])
+			l.readChar()
+		}
+	}
+	l.readChar()
+
+	return token.Token{Type: end, Literal: out.String()}
+}
+
+// readRawString reads a string between backquotes, which is taken as it
+// is and can span several lines.
+func (l *Lexer) readRawString() token.Token {
+	start := l.pos()
+	l.readChar()
+
+	position := l.position
+	l.readUntil(func(c token.Character) bool {
+		return c == '`'
+	})
+	if l.position >= len(l.input) {
+		return l.unterminated(start)
+	}
+	literal := l.input[position:l.position]
+	l.readChar()
+
+	// Carriage returns are dropped, as Go does, so the value doesn't
+	// depend on the line endings of the source.
+	literal = strings.Replace(literal, "\r", "", -1)
+
+	return token.Token{Type: token.STRING, Literal: literal}
+}
+
+// unterminated reports a string starting at start that reaches the end of
+// the input, returning it as an illegal token.
+func (l *Lexer) unterminated(start token.Position) token.Token {
+	l.errorf(start, diagnostic.UnterminatedString, "string literal not terminated")
+	return token.Token{Type: token.ILLEGAL, Literal: l.input[start.Offset:l.position]}
+}
+
+// errorf reports an error spanning from pos to the current character.
+func (l *Lexer) errorf(pos token.Position, code diagnostic.Code, format string, args ...interface{}) {
+	l.errors = append(l.errors, &diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Pos:      pos,
+		End:      l.pos(),
+	})
+}
+
+// readChar reads the next character in the stream, decoding it from UTF-8,
+// and advances the lexer position. Bytes that aren't valid UTF-8 are
+// reported and read as unicode.ReplacementChar.
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
-		l.ch = token.Character(0)
+	if l.readPosition > len(l.input) {
+		// Already at EOF.
+		return
+	}
+
+	if l.readPosition > 0 {
+		if l.ch == '\n' {
+			l.line++
+			l.column = 1
+		} else {
+			l.column++
+		}
 	} else {
-		l.ch = token.Character(l.input[l.readPosition])
+		l.column = 1
 	}
+
 	l.position = l.readPosition
-	l.readPosition++
+	if l.position >= len(l.input) {
+		l.ch = token.Character(0)
+		l.readPosition++
+		return
+	}
+
+	r, size := rune(l.input[l.position]), 1
+	if r >= utf8.RuneSelf {
+		r, size = utf8.DecodeRuneInString(l.input[l.position:])
+		if r == utf8.RuneError && size == 1 {
+			pos, end := l.pos(), l.pos()
+			end.Offset++
+			end.Column++
+			l.errors = append(l.errors, &diagnostic.Diagnostic{
+				Severity: diagnostic.Error,
+				Code:     diagnostic.InvalidUTF8,
+				Message:  fmt.Sprintf("invalid UTF-8 encoding %#x", l.input[l.position]),
+				Pos:      pos,
+				End:      end,
+			})
+		}
+	}
+	l.ch = token.Character(r)
+	l.readPosition += size
+}
+
+// pos returns the position of the current character.
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
 }
 
 func (l *Lexer) peekChar() byte {
@@ -147,6 +400,14 @@ func (l *Lexer) peekChar() byte {
 	}
 }
 
+// peekCharAt returns the byte n positions after the current one.
+func (l *Lexer) peekCharAt(n int) byte {
+	if l.position+n >= len(l.input) {
+		return 0
+	}
+	return l.input[l.position+n]
+}
+
 // readWhile acts like readChar, but continues reading until the given
 // predicate is false.
 func (l *Lexer) readWhile(predicate func(token.Character) bool) string {
@@ -3,6 +3,7 @@ package lexer
 import (
 	"testing"
 
+	"github.com/danielrs/monkey/diagnostic"
 	"github.com/danielrs/monkey/token"
 )
 
@@ -175,3 +176,305 @@ func TestNextToken(t *testing.T) {
 		}
 	}
 }
+
+func TestNumbers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []token.Token
+	}{
+		{"3.14", []token.Token{{Type: token.FLOAT, Literal: "3.14"}}},
+		{"1e-9", []token.Token{{Type: token.FLOAT, Literal: "1e-9"}}},
+		{"2.5E+3", []token.Token{{Type: token.FLOAT, Literal: "2.5E+3"}}},
+		{"1e10", []token.Token{{Type: token.FLOAT, Literal: "1e10"}}},
+		{"007", []token.Token{{Type: token.INT, Literal: "7"}}},
+		{"00", []token.Token{{Type: token.INT, Literal: "0"}}},
+		{"1.", []token.Token{
+			{Type: token.INT, Literal: "1"},
+			{Type: token.ILLEGAL, Literal: "."},
+		}},
+		{"2e", []token.Token{
+			{Type: token.INT, Literal: "2"},
+			{Type: token.IDENT, Literal: "e"},
+		}},
+		{"2e+", []token.Token{
+			{Type: token.INT, Literal: "2"},
+			{Type: token.IDENT, Literal: "e"},
+			{Type: token.PLUS, Literal: "+"},
+		}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		for _, want := range tt.expected {
+			tok := l.NextToken()
+			if tok.Type != want.Type || tok.Literal != want.Literal {
+				t.Errorf("%q: got %s %q, want %s %q",
+					tt.input, tok.Type, tok.Literal, want.Type, want.Literal)
+			}
+		}
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("%q: got %s %q, want EOF", tt.input, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestOperators(t *testing.T) {
+	input := "=> ... <= >= < > += -= *= /= %= + - * / % //"
+
+	expected := []token.TokenType{
+		token.ARROW, token.ELLIPSIS,
+		token.LT_EQ, token.GT_EQ, token.LT, token.GT,
+		token.PLUS_ASSIGN, token.MINUS_ASSIGN, token.ASTERISK_ASSIGN,
+		token.SLASH_ASSIGN, token.MOD_ASSIGN,
+		token.PLUS, token.MINUS, token.ASTERISK, token.SLASH, token.MOD,
+		token.COMMENT, token.EOF,
+	}
+
+	l := New(input)
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want {
+			t.Fatalf("tests[%d]: got %s %q, want %s", i, tok.Type, tok.Literal, want)
+		}
+		if want != token.COMMENT && want != token.EOF && tok.Literal != string(want) {
+			t.Errorf("tests[%d]: literal is %q, want %q", i, tok.Literal, want)
+		}
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	input := "while for in break continue const match fn iffy"
+
+	expected := []token.TokenType{
+		token.WHILE, token.FOR, token.IN, token.BREAK, token.CONTINUE,
+		token.CONST, token.MATCH, token.FUNCTION, token.IDENT, token.EOF,
+	}
+
+	l := New(input)
+	for i, want := range expected {
+		tok := l.NextToken()
+		if tok.Type != want {
+			t.Fatalf("tests[%d]: got %s %q, want %s", i, tok.Type, tok.Literal, want)
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a\tb\nc"`, "a\tb\nc"},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"back\\slash"`, `back\slash`},
+		{`"\x41\u00e9\U0001F600"`, "A\u00e9\U0001F600"},
+		{`"\101"`, "A"},
+		{`"héllo"`, "héllo"},
+		{"`raw \\n \"string\"`", `raw \n "string"`},
+		{"`two\r\nlines`", "two\nlines"},
+		{`""`, ""},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.STRING || tok.Literal != tt.expected {
+			t.Errorf("%s: got %s %q, want STRING %q", tt.input, tok.Type, tok.Literal, tt.expected)
+		}
+		if len(l.Errors()) != 0 {
+			t.Errorf("%s: got errors %v", tt.input, l.Errors())
+		}
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("%s: got %s %q, want EOF", tt.input, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestInterpolation(t *testing.T) {
+	input := `"a${x}b${ {"k": "${y}"}["k"] }c" "\${z}"`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INTERP_START, "a"},
+		{token.IDENT, "x"},
+		{token.INTERP_MID, "b"},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.INTERP_START, ""},
+		{token.IDENT, "y"},
+		{token.INTERP_END, ""},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.STRING, "k"},
+		{token.RBRACKET, "]"},
+		{token.INTERP_END, "c"},
+		{token.STRING, "${z}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d]: got %s %q, want %s %q",
+				i, tok.Type, tok.Literal, tt.expectedType, tt.expectedLiteral)
+		}
+	}
+}
+
+func TestStringErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		tokType  token.TokenType
+		code     diagnostic.Code
+		position string
+		message  string
+	}{
+		{`"abc`, token.ILLEGAL, diagnostic.UnterminatedString, "1:1",
+			"string literal not terminated"},
+		{"x = `abc", token.ILLEGAL, diagnostic.UnterminatedString, "1:5",
+			"string literal not terminated"},
+		{`"abc\"`, token.ILLEGAL, diagnostic.UnterminatedString, "1:1",
+			"string literal not terminated"},
+		{`"a\qb"`, token.STRING, diagnostic.InvalidEscape, "1:3",
+			`invalid escape sequence \q`},
+		{`"\xZZ"`, token.STRING, diagnostic.InvalidEscape, "1:2",
+			`invalid escape sequence \x`},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		var tok token.Token
+		for tok = l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			if tok.Type == token.ILLEGAL || tok.Type == token.STRING {
+				break
+			}
+		}
+		if tok.Type != tt.tokType {
+			t.Errorf("%s: got %s, want %s", tt.input, tok.Type, tt.tokType)
+		}
+
+		errors := l.Errors()
+		if len(errors) != 1 {
+			t.Errorf("%s: got %d errors, want 1", tt.input, len(errors))
+			continue
+		}
+		d := errors[0]
+		if d.Code != tt.code || d.Pos.String() != tt.position || d.Message != tt.message {
+			t.Errorf("%s: got %s, want %s: error[%s]: %s",
+				tt.input, d, tt.position, tt.code, tt.message)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let größe = \"日本\"; _名前 + π € x"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedPos     [3]int // offset, line, column
+	}{
+		{token.LET, "let", [3]int{0, 1, 1}},
+		{token.IDENT, "größe", [3]int{4, 1, 5}},
+		{token.ASSIGN, "=", [3]int{12, 1, 11}},
+		{token.STRING, "日本", [3]int{14, 1, 13}},
+		{token.SEMICOLON, ";", [3]int{22, 1, 17}},
+		{token.IDENT, "_名前", [3]int{24, 1, 19}},
+		{token.PLUS, "+", [3]int{32, 1, 23}},
+		{token.IDENT, "π", [3]int{34, 1, 25}},
+		{token.ILLEGAL, "€", [3]int{37, 1, 27}},
+		{token.IDENT, "x", [3]int{41, 1, 29}},
+		{token.EOF, "", [3]int{42, 1, 30}},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		pos := [3]int{tok.Pos.Offset, tok.Pos.Line, tok.Pos.Column}
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral || pos != tt.expectedPos {
+			t.Errorf("tests[%d]: got %s %q at %v, want %s %q at %v", i,
+				tok.Type, tok.Literal, pos, tt.expectedType, tt.expectedLiteral, tt.expectedPos)
+		}
+	}
+	if len(l.Errors()) != 0 {
+		t.Errorf("got errors %v", l.Errors())
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	l := New("let a = \"x\xffy\";\n\xc3")
+
+	var str token.Token
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		if tok.Type == token.STRING {
+			str = tok
+		}
+	}
+	// Strings keep their bytes as they are.
+	if str.Literal != "x\xffy" {
+		t.Errorf("string literal is %q, want %q", str.Literal, "x\xffy")
+	}
+
+	errors := l.Errors()
+	expected := []string{
+		"1:11: error[L0003]: invalid UTF-8 encoding 0xff",
+		"2:1: error[L0003]: invalid UTF-8 encoding 0xc3",
+	}
+	if len(errors) != len(expected) {
+		t.Fatalf("got %d errors, want %d: %v", len(errors), len(expected), errors)
+	}
+	for i, d := range errors {
+		if d.Error() != expected[i] {
+			t.Errorf("errors[%d] is %q, want %q", i, d.Error(), expected[i])
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 5;\n  \"foo\" // bar\n}"
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  [3]int // offset, line, column
+		expectedEnd  [3]int
+	}{
+		{token.LET, [3]int{0, 1, 1}, [3]int{3, 1, 4}},
+		{token.IDENT, [3]int{4, 1, 5}, [3]int{5, 1, 6}},
+		{token.ASSIGN, [3]int{6, 1, 7}, [3]int{7, 1, 8}},
+		{token.INT, [3]int{8, 1, 9}, [3]int{9, 1, 10}},
+		{token.SEMICOLON, [3]int{9, 1, 10}, [3]int{10, 1, 11}},
+		{token.STRING, [3]int{13, 2, 3}, [3]int{18, 2, 8}},
+		{token.COMMENT, [3]int{19, 2, 9}, [3]int{25, 2, 15}},
+		{token.RBRACE, [3]int{26, 3, 1}, [3]int{27, 3, 2}},
+		{token.EOF, [3]int{27, 3, 2}, [3]int{27, 3, 2}},
+		{token.EOF, [3]int{27, 3, 2}, [3]int{27, 3, 2}},
+	}
+
+	l := NewFile("test.monkey", input)
+
+	for _, tc := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tc.expectedType {
+			t.Errorf("Token type. Got %s, want %s", tok.Type, tc.expectedType)
+		}
+
+		if tok.Pos.Filename != "test.monkey" {
+			t.Errorf("Filename. Got %q, want %q", tok.Pos.Filename, "test.monkey")
+		}
+
+		pos := [3]int{tok.Pos.Offset, tok.Pos.Line, tok.Pos.Column}
+		if pos != tc.expectedPos {
+			t.Errorf("Pos of %s. Got %v, want %v", tok.Type, pos, tc.expectedPos)
+		}
+
+		end := [3]int{tok.End.Offset, tok.End.Line, tok.End.Column}
+		if end != tc.expectedEnd {
+			t.Errorf("End of %s. Got %v, want %v", tok.Type, end, tc.expectedEnd)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Package monkey embeds the Monkey programming language in Go programs.
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/danielrs/monkey/diagnostic"
+	"github.com/danielrs/monkey/evaluator"
+	"github.com/danielrs/monkey/lexer"
+	"github.com/danielrs/monkey/object"
+	"github.com/danielrs/monkey/parser"
+)
+
+// Interpreter runs Monkey programs, keeping the bindings they make from
+// one call to the next. Interpreters don't share any state, but a single
+// one must not be used from several goroutines at once.
+type Interpreter struct {
+	// Standard streams of the programs. Nil ones are the ones of the
+	// process.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Builtin functions available to the programs, initially the
+	// standard ones.
+	Builtins evaluator.Builtins
+
+	// Limits of each call to Eval, EvalFile and Call.
+	Limits evaluator.Limits
+
+	// Strict makes indexes and slice bounds out of range errors, rather
+	// than giving null or stopping at the ends.
+	Strict bool
+
+	env *object.Environment
+}
+
+// Returns a pointer to a new Interpreter.
+func New() *Interpreter {
+	return &Interpreter{
+		Builtins: evaluator.DefaultBuiltins(),
+		env:      object.NewEnvironment(),
+	}
+}
+
+// Eval runs src, returning its value. Syntax errors are returned as a
+// *SyntaxError and runtime errors as an *object.Error.
+func (i *Interpreter) Eval(src string) (object.Object, error) {
+	return i.EvalContext(context.Background(), src)
+}
+
+// EvalContext is like Eval, but stops when ctx is done.
+func (i *Interpreter) EvalContext(ctx context.Context, src string) (object.Object, error) {
+	return i.eval(ctx, "", src)
+}
+
+// EvalFile runs the program in the file at path, like Eval does.
+func (i *Interpreter) EvalFile(path string) (object.Object, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return i.eval(context.Background(), path, string(data))
+}
+
+// Call calls the function bound to name, which can be a builtin, with the
+// given arguments.
+func (i *Interpreter) Call(name string, args ...object.Object) (object.Object, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is like Call, but stops when ctx is done.
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...object.Object) (object.Object, error) {
+	fn, ok := i.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("monkey: %s is not defined", name)
+	}
+	return result(i.evaluator().CallContext(ctx, fn, args...))
+}
+
+// Get returns the value bound to name, which can be a builtin.
+func (i *Interpreter) Get(name string) (object.Object, bool) {
+	if obj, ok := i.env.Get(name); ok {
+		return obj, true
+	}
+	if builtin, ok := i.Builtins[name]; ok {
+		return builtin, true
+	}
+	return nil, false
+}
+
+// Set binds name to value, as a let statement would. A nil value is bound
+// as NULL.
+func (i *Interpreter) Set(name string, value object.Object) {
+	if value == nil {
+		value = evaluator.NULL
+	}
+	i.env.Set(name, value)
+}
+
+func (i *Interpreter) eval(ctx context.Context, filename, src string) (object.Object, error) {
+	l := lexer.NewFile(filename, src)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &SyntaxError{Source: src, Diagnostics: p.Errors()}
+	}
+
+	return result(i.evaluator().EvalContext(ctx, i.env, program))
+}
+
+func (i *Interpreter) evaluator() *evaluator.Evaluator {
+	e := evaluator.NewWithLimits(i.Limits)
+	e.SetBuiltins(i.Builtins)
+	e.SetStreams(i.Stdin, i.Stdout, i.Stderr)
+	e.SetStrict(i.Strict)
+	return e
+}
+
+func result(obj object.Object) (object.Object, error) {
+	if err, ok := obj.(*object.Error); ok {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// SyntaxError is returned for programs that can't be parsed. Diagnostics
+// has every error found, which can be rendered against Source.
+type SyntaxError struct {
+	Source      string
+	Diagnostics []*diagnostic.Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	msg := e.Diagnostics[0].Error()
+	if n := len(e.Diagnostics) - 1; n > 0 {
+		msg += fmt.Sprintf(" (and %d more errors)", n)
+	}
+	return msg
+}
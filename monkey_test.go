@@ -0,0 +1,122 @@
+package monkey
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/danielrs/monkey/object"
+)
+
+func TestEval(t *testing.T) {
+	var out bytes.Buffer
+	interp := New()
+	interp.Stdout = &out
+
+	if _, err := interp.Eval(`let greet = fn(name) { print("hello", name) };`); err != nil {
+		t.Fatalf("interp.Eval returned error: %s", err)
+	}
+	result, err := interp.Eval(`greet("world"); 1 + 2`)
+	if err != nil {
+		t.Fatalf("interp.Eval returned error: %s", err)
+	}
+
+	if result.Inspect() != "3" {
+		t.Errorf("result is %s, want 3", result.Inspect())
+	}
+	if out.String() != "\"hello\" \"world\"\n" {
+		t.Errorf("output is %q, want %q", out.String(), "\"hello\" \"world\"\n")
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	interp := New()
+
+	_, err := interp.Eval("let x = ;")
+	if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("error is %T (%v), want *SyntaxError", err, err)
+	}
+
+	_, err = interp.Eval("1 + true")
+	errobj, ok := err.(*object.Error)
+	if !ok {
+		t.Fatalf("error is %T (%v), want *object.Error", err, err)
+	}
+	if errobj.Error() != "1:1: type mismatch: INTEGER + BOOLEAN" {
+		t.Errorf("errobj.Error() is %q", errobj.Error())
+	}
+
+	interp.Strict = true
+	_, err = interp.Eval("[1, 2][2]")
+	if err == nil || err.Error() != "1:1: index out of range: 2 with length 2" {
+		t.Errorf("error in strict mode is %v", err)
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := New()
+	if _, err := interp.Eval("let add = fn(a, b) { a + b };"); err != nil {
+		t.Fatalf("interp.Eval returned error: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []object.Object
+		expected string
+		err      string
+	}{
+		{"add", []object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 2}}, "3", ""},
+		{"len", []object.Object{&object.String{Value: "four"}}, "4", ""},
+		{"add", []object.Object{&object.Integer{Value: 1}}, "", "argument mismatch: got 1, want 2"},
+		{"missing", nil, "", "monkey: missing is not defined"},
+		{"len", []object.Object{nil}, "", "argument to `len` not supported, got NIL"},
+	}
+
+	for _, tt := range tests {
+		result, err := interp.Call(tt.name, tt.args...)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("calling %s returned error %v, want %s", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("calling %s returned error: %s", tt.name, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("calling %s returned %s, want %s", tt.name, result.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestIsolation(t *testing.T) {
+	var out1, out2 bytes.Buffer
+	interp1, interp2 := New(), New()
+	interp1.Stdout = &out1
+	interp2.Stdout = &out2
+
+	interp1.Builtins["double"] = &object.Builtin{
+		Fn: func(rt object.Runtime, args ...object.Object) object.Object {
+			n := args[0].(*object.Integer).Value
+			return &object.Integer{Value: 2 * n}
+		},
+	}
+
+	if _, err := interp1.Eval("let x = double(21); print(x)"); err != nil {
+		t.Fatalf("interp1.Eval returned error: %s", err)
+	}
+	if _, err := interp2.Eval("x"); err == nil {
+		t.Errorf("x is defined in interp2")
+	}
+	if _, err := interp2.Eval("double(1)"); err == nil {
+		t.Errorf("double is defined in interp2")
+	}
+	if _, err := interp2.Eval("print(1)"); err != nil {
+		t.Fatalf("interp2.Eval returned error: %s", err)
+	}
+
+	if out1.String() != "42\n" || out2.String() != "1\n" {
+		t.Errorf("outputs are %q and %q, want %q and %q",
+			out1.String(), out2.String(), "42\n", "1\n")
+	}
+}
@@ -2,11 +2,20 @@ package object
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash"
 	"hash/fnv"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
 	"strings"
 
 	"github.com/danielrs/monkey/ast"
+	"github.com/danielrs/monkey/code"
+	"github.com/danielrs/monkey/token"
 )
 
 type ObjectType string
@@ -14,21 +23,27 @@ type ObjectType string
 const (
 	NIL_OBJ          = "NIL"
 	INTEGER_OBJ      = "INTEGER"
+	FLOAT_OBJ        = "FLOAT"
 	BOOLEAN_OBJ      = "BOOLEAN"
 	STRING_OBJ       = "STRING"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
 	FUNCTION_OBJ     = "FUNCTION_OBJ"
 	ARRAY_OBJ        = "ARRAY_OBJ"
 	HASH_OBJ         = "HASH_OBJ"
+	RANGE_OBJ        = "RANGE"
 	BUILTIN_OBJ      = "BUILTIN"
 	ERROR_OBJ        = "ERROR_OBJ"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
+	PATTERN_OBJ           = "PATTERN"
 )
 
 // Environment.
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	consts map[string]bool
+	outer  *Environment
 }
 
 func NewEnvironment() *Environment {
@@ -49,13 +64,46 @@ func (e *Environment) Get(name string) (Object, bool) {
 	return obj, ok
 }
 
+// Set binds name to val in this environment. If name was a constant it
+// stops being one.
 func (e *Environment) Set(name string, val Object) Object {
 	e.store[name] = val
+	delete(e.consts, name)
 	return val
 }
 
+// SetConst is like Set, but name becomes a constant, which can't be
+// assigned to.
+func (e *Environment) SetConst(name string, val Object) Object {
+	e.store[name] = val
+	if e.consts == nil {
+		e.consts = make(map[string]bool)
+	}
+	e.consts[name] = true
+	return val
+}
+
+// IsConst reports whether name is a constant in this environment.
+func (e *Environment) IsConst(name string) bool {
+	return e.consts[name]
+}
+
+// Resolve returns the environment where name is bound, which is this one
+// or an outer one.
+func (e *Environment) Resolve(name string) (*Environment, bool) {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			return env, true
+		}
+	}
+	return nil, false
+}
+
 // HashKey
 
+// Hasher is implemented by the values that can always be hash keys.
+// Arrays and hashes can be keys too, when their contents can; use
+// HashKeyOf to get the key of any value.
 type Hasher interface {
 	HashKey() HashKey
 }
@@ -65,6 +113,60 @@ type HashKey struct {
 	Value uint64
 }
 
+// HashKeyOf returns the key of obj in a hash, or false if it can't be one.
+// Keys agree with ==, so equal values have the same key: arrays have the
+// keys of their elements and hashes of their pairs, and functions are only
+// equal to themselves. As arrays and hashes never change, neither do their
+// keys.
+func HashKeyOf(obj Object) (HashKey, bool) {
+	switch obj := obj.(type) {
+	case Hasher:
+		return obj.HashKey(), true
+
+	case *Array:
+		h := fnv.New64a()
+		for _, el := range obj.Elements {
+			key, ok := HashKeyOf(el)
+			if !ok {
+				return HashKey{}, false
+			}
+			writeHashKey(h, key)
+		}
+		return HashKey{Type: obj.Type(), Value: h.Sum64()}, true
+
+	case *Hash:
+		// The pairs are in no particular order, so their hashes are
+		// added up.
+		var sum uint64
+		for key, pair := range obj.Pairs {
+			value, ok := HashKeyOf(pair.Value)
+			if !ok {
+				return HashKey{}, false
+			}
+			h := fnv.New64a()
+			writeHashKey(h, key)
+			writeHashKey(h, value)
+			sum += h.Sum64()
+		}
+		return HashKey{Type: obj.Type(), Value: sum}, true
+	}
+
+	return HashKey{}, false
+}
+
+func writeHashKey(h hash.Hash64, key HashKey) {
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], key.Value)
+	h.Write([]byte(key.Type))
+	h.Write(value[:])
+}
+
+// identityKey returns a key that is only the key of obj, for values
+// equal only to themselves.
+func identityKey(obj Object) HashKey {
+	return HashKey{Type: obj.Type(), Value: uint64(reflect.ValueOf(obj).Pointer())}
+}
+
 // Individual objects.
 
 type Object interface {
@@ -76,6 +178,9 @@ type Nil struct{}
 
 func (n *Nil) Type() ObjectType { return NIL_OBJ }
 func (n *Nil) Inspect() string  { return "nil" }
+func (n *Nil) HashKey() HashKey {
+	return HashKey{Type: n.Type()}
+}
 
 type Boolean struct {
 	Value bool
@@ -105,6 +210,65 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// BigInteger is an integer too large for an Integer. Arithmetic promotes
+// integers to big ones on overflow and demotes results that fit back, so
+// a BigInteger never holds a value an Integer could. To Monkey code both
+// are INTEGER.
+
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (bi *BigInteger) Type() ObjectType { return INTEGER_OBJ }
+func (bi *BigInteger) Inspect() string  { return bi.Value.String() }
+func (bi *BigInteger) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write(bi.Value.Bytes())
+	if bi.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	return HashKey{Type: bi.Type(), Value: h.Sum64()}
+}
+
+// NewInteger returns an Integer for v if it fits in one, or a BigInteger
+// otherwise.
+func NewInteger(v *big.Int) Object {
+	if v.IsInt64() {
+		return &Integer{Value: v.Int64()}
+	}
+	return &BigInteger{Value: v}
+}
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always shows floats with a fractional part or an exponent, so
+// they can be told apart from integers.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+// Floats with an integer value hash like the integer, as they are equal.
+// Whole floats have the key of the integer they are equal to.
+func (f *Float) HashKey() HashKey {
+	if f.Value >= math.MinInt64 && f.Value < math.MaxInt64 && f.Value == math.Trunc(f.Value) {
+		i := int64(f.Value)
+		return (&Integer{Value: i}).HashKey()
+	}
+	if !math.IsInf(f.Value, 0) && f.Value == math.Trunc(f.Value) {
+		i, _ := big.NewFloat(f.Value).Int(nil)
+		return (&BigInteger{Value: i}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 type String struct {
 	Value string
 }
@@ -125,12 +289,14 @@ func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
 type Function struct {
+	Name       string // name it was bound to with let, if any
 	Parameters ast.ParameterList
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
+func (f *Function) HashKey() HashKey { return identityKey(f) }
 func (f *Function) Inspect() string {
 	var out bytes.Buffer
 
@@ -144,6 +310,59 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// Objects used by the virtual machine. A CompiledFunction is a constant
+// created by the compiler; calling it requires a Closure, which keeps the
+// locals of the call it was created in so the function can see them.
+
+type CompiledFunction struct {
+	Name          string
+	Instructions  code.Instructions
+	SourceMap     code.SourceMap
+	NumLocals     int
+	NumParameters int
+
+	// Source of the function, for inspecting it.
+	Parameters ast.ParameterList
+	Body       *ast.BlockStatement
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+type Closure struct {
+	Fn     *CompiledFunction
+	Parent *Locals
+}
+
+// Closures are functions as far as Monkey code can tell.
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) HashKey() HashKey { return identityKey(c) }
+func (c *Closure) Inspect() string {
+	f := &Function{Parameters: c.Fn.Parameters, Body: c.Fn.Body}
+	return f.Inspect()
+}
+
+// Locals holds the local slots of a function call, or of a match arm.
+// Parent is the locals of the call the function was created in, or the
+// ones around the arm; nil at the top level.
+
+type Locals struct {
+	Slots  []Object
+	Parent *Locals
+}
+
+// Pattern is a constant holding the pattern of a match arm, which the
+// virtual machine matches values against.
+
+type Pattern struct {
+	Pattern ast.Pattern
+}
+
+func (p *Pattern) Type() ObjectType { return PATTERN_OBJ }
+func (p *Pattern) Inspect() string  { return p.Pattern.String() }
+
 type Array struct {
 	Elements []Object
 }
@@ -164,6 +383,46 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
+// Range is the sequence of integers from Start up to Stop, excluding it,
+// taking steps of Step, which is never 0. Ranges are lazy: their integers
+// are only created when iterating over them.
+
+type Range struct {
+	Start, Stop, Step int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.Stop)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.Stop, r.Step)
+}
+
+// Len returns the number of integers in the range.
+func (r *Range) Len() int64 {
+	// Differences are computed as unsigned so they can't overflow.
+	var diff, step uint64
+	switch {
+	case r.Step > 0 && r.Start < r.Stop:
+		diff, step = uint64(r.Stop-r.Start), uint64(r.Step)
+	case r.Step < 0 && r.Start > r.Stop:
+		diff, step = uint64(r.Start-r.Stop), uint64(-r.Step)
+	default:
+		return 0
+	}
+	n := (diff-1)/step + 1
+	if n > math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(n)
+}
+
+// At returns the i-th integer of the range, which must be less than Len.
+func (r *Range) At(i int64) int64 {
+	return r.Start + int64(uint64(i)*uint64(r.Step))
+}
+
 type HashPair struct {
 	Key   Object
 	Value Object
@@ -190,7 +449,24 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
-type BuiltinFunction func(args ...Object) Object
+type BuiltinFunction func(rt Runtime, args ...Object) Object
+
+// Runtime is the engine running a builtin, giving it access to the
+// standard streams of the program and letting it call back into it.
+type Runtime interface {
+	Stdin() io.Reader
+	Stdout() io.Writer
+	Stderr() io.Writer
+
+	// Call calls a function of the program, or a builtin, returning its
+	// result or the *Error it failed with.
+	Call(fn Object, args ...Object) Object
+
+	// Alloc counts n objects the builtin is about to create against the
+	// limits of the program, returning the *Error to fail with if that
+	// goes over them.
+	Alloc(n int64) *Error
+}
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -198,10 +474,99 @@ type Builtin struct {
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
 func (b *Builtin) Inspect() string  { return "builtin function" }
+func (b *Builtin) HashKey() HashKey { return identityKey(b) }
+
+// Error is a runtime error. Pos is where it happened and Stack the calls
+// that led there, innermost first; the evaluator fills them in.
 
 type Error struct {
+	Kind    ErrorKind
 	Message string
+	Pos     token.Position
+	Stack   []Frame
+}
+
+// ErrorKind tells errors in the program apart from the host stopping it.
+
+type ErrorKind int
+
+const (
+	RuntimeError    ErrorKind = iota // error in the program
+	StepLimitError                   // too many steps
+	DepthLimitError                  // calls nested too deep
+	AllocLimitError                  // too many objects created
+	DeadlineError                    // ran out of time
+	CanceledError                    // canceled by the host
+)
+
+var errorKinds = [...]string{
+	RuntimeError:    "runtime error",
+	StepLimitError:  "step limit",
+	DepthLimitError: "depth limit",
+	AllocLimitError: "allocation limit",
+	DeadlineError:   "deadline",
+	CanceledError:   "canceled",
+}
+
+func (k ErrorKind) String() string {
+	if k >= 0 && int(k) < len(errorKinds) {
+		return errorKinds[k]
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+type Frame struct {
+	Function string
+	Pos      token.Position
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+
+// Error makes runtime errors usable as Go errors.
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
+}
+
+// Deep call stacks are elided in the middle when printed, like in Go,
+// keeping this many frames at the top and at the bottom.
+const (
+	stackTraceTop    = 50
+	stackTraceBottom = 50
+)
+
+// StackTrace returns the error followed by its call stack, in the style of
+// Go panics.
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+
+	out.WriteString(e.Inspect())
+	out.WriteString("\n")
+	if len(e.Stack) > 0 {
+		out.WriteString("\n")
+	}
+	for i, f := range e.Stack {
+		if i == stackTraceTop && len(e.Stack) > stackTraceTop+stackTraceBottom {
+			out.WriteString("...additional frames elided...\n")
+		}
+		if i >= stackTraceTop && i < len(e.Stack)-stackTraceBottom {
+			continue
+		}
+
+		name := f.Function
+		if name == "" {
+			name = "fn"
+		}
+		if name == "main" {
+			out.WriteString("main()\n")
+		} else {
+			out.WriteString(name + "(...)\n")
+		}
+		out.WriteString("\t" + f.Pos.String() + "\n")
+	}
+
+	return out.String()
+}
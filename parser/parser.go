@@ -2,9 +2,12 @@ package parser
 
 import (
 	"fmt"
+	"math/big"
+	"sort"
 	"strconv"
 
 	"github.com/danielrs/monkey/ast"
+	"github.com/danielrs/monkey/diagnostic"
 	"github.com/danielrs/monkey/lexer"
 	"github.com/danielrs/monkey/token"
 )
@@ -13,6 +16,7 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN
 	OR
 	AND
 	EQUALS
@@ -25,19 +29,27 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.ASTERISK: PRODUCT,
-	token.SLASH:    PRODUCT,
-	token.MOD:      PRODUCT,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.OR:       OR,
-	token.AND:      AND,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.MOD_ASSIGN:      ASSIGN,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.ASTERISK:        PRODUCT,
+	token.SLASH:           PRODUCT,
+	token.MOD:             PRODUCT,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.LT_EQ:           LESSGREATER,
+	token.GT_EQ:           LESSGREATER,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.OR:              OR,
+	token.AND:             AND,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 type (
@@ -47,7 +59,28 @@ type (
 
 type Parser struct {
 	l      *lexer.Lexer
-	errors []string
+	errors []*diagnostic.Diagnostic
+
+	// panicking is set after an error is reported and cleared once the
+	// parser synchronizes at the next statement; errors found meanwhile
+	// are most likely caused by the first one, so they are dropped.
+	// errToken is the token the first error was reported at.
+	panicking bool
+	errToken  token.Token
+
+	// lexErrors is the number of lexer diagnostics already moved to
+	// errors.
+	lexErrors int
+
+	// loops is how many loops the current token is in, within the
+	// innermost function; break and continue need at least one.
+	loops int
+
+	// inValue is set while parsing an expression whose value is used,
+	// within the innermost loop. Break and continue can't leave those,
+	// as the value would be missing, so they can only be statements of
+	// the loop or of if statements in it.
+	inValue bool
 
 	curToken  token.Token
 	peekToken token.Token
@@ -63,9 +96,11 @@ func New(l *lexer.Lexer) *Parser {
 	p.prefixParseFns = make(map[token.TokenType]PrefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.TRUE, p.parseBooleanLiteral)
 	p.registerPrefix(token.FALSE, p.parseBooleanLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.INTERP_START, p.parseInterpolatedString)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
@@ -73,6 +108,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 
 	// Register infix functions.
 	p.infixParseFns = make(map[token.TokenType]InfixParseFn)
@@ -85,6 +121,14 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.GT_EQ, p.parseInfixExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MOD_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
@@ -97,31 +141,113 @@ func New(l *lexer.Lexer) *Parser {
 	return p
 }
 
-func (p *Parser) Errors() []string {
+// Errors returns the diagnostics reported while parsing, including the
+// ones of the lexer, in source order.
+func (p *Parser) Errors() []*diagnostic.Diagnostic {
+	sort.SliceStable(p.errors, func(i, j int) bool {
+		return p.errors[i].Pos.Offset < p.errors[j].Pos.Offset
+	})
 	return p.errors
 }
 
+// ParseProgram parses the whole input. When there are errors it still
+// returns a best-effort program where the parts that couldn't be parsed are
+// replaced by ast.BadStatement and ast.BadExpression nodes.
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
-	program.Statements = make([]ast.Statement, 0)
+	program.Statements = p.parseStatementList(token.EOF)
+	return program
+}
+
+// parseStatementList parses statements until the end token (or EOF) is
+// found, recovering from errors at statement boundaries.
+func (p *Parser) parseStatementList(end token.TokenType) []ast.Statement {
+	list := make([]ast.Statement, 0)
 
-	for p.curToken.Type != token.EOF {
+	for !p.curTokenIs(end) && !p.curTokenIs(token.EOF) {
+		start := p.curToken
 		stmt := p.parseStatement()
+
+		if p.panicking {
+			to := p.synchronize(start)
+			if stmt == nil {
+				stmt = &ast.BadStatement{From: start.Pos, To: to}
+			}
+			list = append(list, stmt)
+			// The parser is already on the next statement.
+			continue
+		}
+
 		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
+			list = append(list, stmt)
 		}
 		p.nextToken()
 	}
 
-	return program
+	return list
+}
+
+// synchronize skips tokens after an error until the parser is at the start
+// of the next statement: after a semicolon, on a keyword starting a
+// statement, or on a closing brace that is not nested within the skipped
+// tokens. It always moves past the start token of the failed statement and
+// returns the end of the last token skipped.
+func (p *Parser) synchronize(start token.Token) token.Position {
+	end := start.End
+	skip := func() {
+		end = p.curToken.End
+		p.nextToken()
+	}
+
+	// Tokens before the offending one were parsed fine.
+	for p.curToken.Pos.Offset < p.errToken.Pos.Offset && !p.curTokenIs(token.EOF) {
+		skip()
+	}
+	if p.curToken.Pos == start.Pos && !p.curTokenIs(token.EOF) {
+		skip()
+	}
+
+	depth := 0
+loop:
+	for !p.curTokenIs(token.EOF) {
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				break loop
+			}
+			depth--
+		case token.SEMICOLON:
+			if depth == 0 {
+				skip()
+				break loop
+			}
+		case token.LET, token.CONST, token.RETURN, token.WHILE, token.FOR,
+			token.BREAK, token.CONTINUE:
+			if depth == 0 {
+				break loop
+			}
+		}
+		skip()
+	}
+
+	p.panicking = false
+	return end
 }
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseBranchStatement()
 	case token.COMMENT:
 		// ignore comments.
 		return nil
@@ -130,8 +256,9 @@ func (p *Parser) parseStatement() ast.Statement {
 	}
 }
 
-func (p *Parser) parseLetStatement() *ast.LetStatement {
-	if !p.curTokenIs(token.LET) {
+// parseLetStatement parses a let or const statement.
+func (p *Parser) parseLetStatement() ast.Statement {
+	if !p.curTokenIs(token.LET) && !p.curTokenIs(token.CONST) {
 		return nil
 	}
 
@@ -150,6 +277,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if fn, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fn.Name = stmt.Identifier.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -157,7 +288,7 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	return stmt
 }
 
-func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
+func (p *Parser) parseReturnStatement() ast.Statement {
 	if !p.curTokenIs(token.RETURN) {
 		return nil
 	}
@@ -173,10 +304,121 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	inValue := p.inValue
+	p.loops++
+	p.inValue = false
+	defer func() {
+		p.loops--
+		p.inValue = inValue
+	}()
+	return p.parseBlockStatement()
+}
+
+// parseBranchStatement parses a break or continue statement, which must
+// be in a loop and not in an expression whose value is used.
+func (p *Parser) parseBranchStatement() ast.Statement {
+	stmt := &ast.BranchStatement{Token: p.curToken}
+
+	if p.loops == 0 {
+		p.errorf(p.curToken, diagnostic.InvalidLoopControl,
+			"%s is not in a loop", p.curToken.Literal)
+		return nil
+	}
+	if p.inValue {
+		p.errorf(p.curToken, diagnostic.InvalidLoopControl,
+			"%s cannot leave an expression whose value is used", p.curToken.Literal)
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
-	stmt.Expression = p.parseExpression(LOWEST)
+	if !p.curTokenIs(token.IF) {
+		stmt.Expression = p.parseExpression(LOWEST)
+	} else {
+		// The value of an if statement isn't used, so its branches can
+		// break out of the loop it is in; unless it goes on as part of a
+		// larger expression after all.
+		expr := p.parseIfExpression()
+		if p.continuesExpression(LOWEST) {
+			if branch := loopControl(expr); branch != nil {
+				p.errorf(branch.Token, diagnostic.InvalidLoopControl,
+					"%s cannot leave an expression whose value is used", branch.TokenLiteral())
+			}
+		}
+		stmt.Expression = p.parseInfixExpressions(expr, LOWEST)
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -188,6 +430,11 @@ func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+
+	if lexErrors := p.l.Errors(); len(lexErrors) > p.lexErrors {
+		p.errors = append(p.errors, lexErrors[p.lexErrors:]...)
+		p.lexErrors = len(lexErrors)
+	}
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -229,18 +476,23 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn InfixParseFn) {
 }
 
 func (p *Parser) parseExpression(precedence int) ast.Expression {
+	inValue := p.inValue
+	p.inValue = true
+	defer func() { p.inValue = inValue }()
+
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		p.noPrefixParseFnError(p.curToken)
+		return p.badExpression(p.curToken)
 	}
-	leftExpr := prefix()
+	return p.parseInfixExpressions(prefix(), precedence)
+}
 
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+// parseInfixExpressions parses the operators binding tighter than
+// precedence that follow leftExpr.
+func (p *Parser) parseInfixExpressions(leftExpr ast.Expression, precedence int) ast.Expression {
+	for p.continuesExpression(precedence) {
 		infix := p.infixParseFns[p.peekToken.Type]
-		if infix == nil {
-			return leftExpr
-		}
 		p.nextToken()
 		leftExpr = infix(leftExpr)
 	}
@@ -248,17 +500,59 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExpr
 }
 
+// continuesExpression tells whether the next token is an operator binding
+// tighter than precedence.
+func (p *Parser) continuesExpression(precedence int) bool {
+	return !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() &&
+		p.infixParseFns[p.peekToken.Type] != nil
+}
+
+// loopControl returns a break or continue statement in node that would
+// leave it, or nil if there is none.
+func loopControl(node ast.Node) *ast.BranchStatement {
+	var branch *ast.BranchStatement
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FunctionLiteral, *ast.WhileStatement, *ast.ForStatement:
+			return false
+		case *ast.BranchStatement:
+			if branch == nil {
+				branch = n
+			}
+		}
+		return branch == nil
+	})
+	return branch
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 10, 64)
+	if err == nil {
+		return &ast.IntegerLiteral{Token: p.curToken, Value: value}
+	}
+
+	// Too large for an int64.
+	if v, ok := new(big.Int).SetString(p.curToken.Literal, 10); ok {
+		return &ast.IntegerLiteral{Token: p.curToken, Big: v}
+	}
+
+	p.errorf(p.curToken, diagnostic.InvalidLiteral,
+		"couldn't parse %q as integer", p.curToken.Literal)
+	return p.badExpression(p.curToken)
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
 	if err != nil {
-		p.errorf("couldn't parse %q as integer", p.curToken.Literal)
-		return nil
+		p.errorf(p.curToken, diagnostic.InvalidLiteral,
+			"couldn't parse %q as float", p.curToken.Literal)
+		return p.badExpression(p.curToken)
 	}
-	return &ast.IntegerLiteral{Token: p.curToken, Value: value}
+	return &ast.FloatLiteral{Token: p.curToken, Value: value}
 }
 
 func (p *Parser) parseBooleanLiteral() ast.Expression {
@@ -269,8 +563,9 @@ func (p *Parser) parseBooleanLiteral() ast.Expression {
 	case "true":
 		value = true
 	default:
-		p.errorf("%t is not a valid boolean value", p.curToken.Literal)
-		return nil
+		p.errorf(p.curToken, diagnostic.InvalidLiteral,
+			"%q is not a valid boolean value", p.curToken.Literal)
+		return p.badExpression(p.curToken)
 	}
 	return &ast.BooleanLiteral{Token: p.curToken, Value: value}
 }
@@ -279,6 +574,36 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	expr := &ast.InterpolatedString{Token: p.curToken}
+
+	for {
+		expr.Parts = append(expr.Parts,
+			&ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal})
+		if p.curTokenIs(token.INTERP_END) {
+			expr.Close = p.curToken
+			return expr
+		}
+
+		p.nextToken()
+		if p.curTokenIs(token.INTERP_MID) || p.curTokenIs(token.INTERP_END) {
+			p.errorf(p.curToken, diagnostic.ExpectedExpression,
+				"empty expression in string interpolation")
+			return p.badExpression(expr.Token)
+		}
+		expr.Parts = append(expr.Parts, p.parseExpression(LOWEST))
+
+		if !p.peekTokenIs(token.INTERP_MID) && !p.peekTokenIs(token.INTERP_END) {
+			d := p.errorf(p.peekToken, diagnostic.UnexpectedToken,
+				"expected } to close interpolated expression, got %s", p.peekToken.Type)
+			d.Expected = []token.TokenType{token.INTERP_MID, token.INTERP_END}
+			d.Found = p.peekToken.Type
+			return p.badExpression(expr.Token)
+		}
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parsePrefixExpression() ast.Expression {
 	expr := &ast.PrefixExpression{
 		Token:    p.curToken,
@@ -292,11 +617,11 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	start := p.curToken
 	p.nextToken()
 	expr := p.parseExpression(LOWEST)
 	if !p.expectPeek(token.RPAREN) {
-		p.errorf("expected closing parenthesis")
-		return nil
+		return p.badExpression(start)
 	}
 	return expr
 }
@@ -315,22 +640,45 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	return expr
 }
 
+// parseAssignExpression parses an assignment to left, which must be an
+// identifier. Assignments are right-associative, so a = b = 1 assigns 1 to
+// b first.
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	expr := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+	}
+
+	name, ok := left.(*ast.Identifier)
+	if !ok {
+		p.errorf(p.curToken, diagnostic.InvalidAssignment,
+			"cannot assign to %s", left.String())
+		return p.badExpression(p.curToken)
+	}
+	expr.Name = name
+
+	p.nextToken()
+	expr.Value = p.parseExpression(ASSIGN - 1)
+
+	return expr
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	expr := &ast.IfExpression{Token: p.curToken}
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(expr.Token)
 	}
 
 	p.nextToken()
 	expr.Condition = p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(expr.Token)
 	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(expr.Token)
 	}
 
 	expr.Consequence = p.parseBlockStatement()
@@ -338,7 +686,7 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
 		if !p.expectPeek(token.LBRACE) {
-			return nil
+			return p.badExpression(expr.Token)
 		}
 		expr.Alternative = p.parseBlockStatement()
 	}
@@ -346,18 +694,188 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expr
 }
 
-func (p *Parser) parseBlockStatement() *ast.BlockStatement {
-	block := &ast.BlockStatement{Token: p.curToken}
-	block.Statements = make([]ast.Statement, 0)
+// parseMatchExpression parses a match expression, whose arms are
+// separated by commas.
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expr := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return p.badExpression(expr.Token)
+	}
 
 	p.nextToken()
+	expr.Value = p.parseExpression(LOWEST)
 
-	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
+	if !p.expectPeek(token.RPAREN) {
+		return p.badExpression(expr.Token)
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return p.badExpression(expr.Token)
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return p.badExpression(expr.Token)
+		}
+		expr.Arms = append(expr.Arms, arm)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return p.badExpression(expr.Token)
+		}
+	}
+
+	p.nextToken()
+	expr.Rbrace = p.curToken
+
+	return expr
+}
+
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{Pattern: p.parsePattern()}
+	if arm.Pattern == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	p.nextToken()
+	arm.Body = p.parseExpression(LOWEST)
+
+	return arm
+}
+
+// parsePattern parses the pattern starting at the current token. It
+// returns nil if there is none.
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		return &ast.BindingPattern{Name: ident}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseHashPattern()
+	}
+
+	if lit := p.parsePatternLiteral(); lit != nil {
+		return &ast.LiteralPattern{Value: lit}
+	}
+	return nil
+}
+
+// parsePatternLiteral parses the literal of a literal pattern or the key
+// of a hash pattern: a number, which can be negated, a string or a
+// boolean.
+func (p *Parser) parsePatternLiteral() ast.Expression {
+	switch p.curToken.Type {
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE:
+		return p.prefixParseFns[p.curToken.Type]()
+	case token.MINUS:
+		if p.peekTokenIs(token.INT) || p.peekTokenIs(token.FLOAT) {
+			expr := &ast.PrefixExpression{Token: p.curToken, Operator: "-"}
+			p.nextToken()
+			expr.Right = p.prefixParseFns[p.curToken.Type]()
+			return expr
+		}
+	}
+
+	d := p.errorf(p.curToken, diagnostic.InvalidPattern,
+		"expected pattern, got %s", p.curToken.Type)
+	d.Found = p.curToken.Type
+	return nil
+}
+
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.peekTokenIs(token.RBRACKET) {
+				p.errorf(p.peekToken, diagnostic.InvalidPattern,
+					"...%s must be the last element of the pattern", pattern.Rest)
+				return nil
+			}
+			break
 		}
+
+		el := p.parsePattern()
+		if el == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, el)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+	pattern.Rbracket = p.curToken
+
+	return pattern
+}
+
+func (p *Parser) parseHashPattern() ast.Pattern {
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
+		key := p.parsePatternLiteral()
+		if key == nil {
+			return nil
+		}
+
+		if !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parsePattern()
+		if value == nil {
+			return nil
+		}
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, value)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	p.nextToken()
+	pattern.Rbrace = p.curToken
+
+	return pattern
+}
+
+func (p *Parser) parseBlockStatement() *ast.BlockStatement {
+	block := &ast.BlockStatement{Token: p.curToken}
+
+	p.nextToken()
+	block.Statements = p.parseStatementList(token.RBRACE)
+
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken
+	} else {
+		p.errorf(p.curToken, diagnostic.UnexpectedToken,
+			"expected %s to close block, got %s", token.RBRACE, p.curToken.Type)
 	}
 
 	return block
@@ -368,14 +886,14 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	fn.Parameters = make([]*ast.Identifier, 0)
 
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(fn.Token)
 	}
 
 	for p.peekTokenIs(token.IDENT) {
 		p.nextToken()
 		ident, ok := p.parseIdentifier().(*ast.Identifier)
 		if !ok {
-			return nil
+			return p.badExpression(fn.Token)
 		}
 		fn.Parameters = append(fn.Parameters, ident)
 		if p.peekTokenIs(token.COMMA) {
@@ -384,14 +902,18 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 	}
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(fn.Token)
 	}
 
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(fn.Token)
 	}
 
+	// Loops around the function don't continue in it.
+	loops := p.loops
+	p.loops = 0
 	fn.Body = p.parseBlockStatement()
+	p.loops = loops
 
 	return fn
 }
@@ -399,18 +921,27 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if p.curTokenIs(token.RBRACKET) {
+		array.Rbracket = p.curToken
+	}
 	return array
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	hash.Pairs = p.parsePairList(token.RBRACE)
+	if p.curTokenIs(token.RBRACE) {
+		hash.Rbrace = p.curToken
+	}
 	return hash
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	expr := &ast.CallExpression{Token: p.curToken, Function: function}
 	expr.Arguments = p.parseExpressionList(token.RPAREN)
+	if p.curTokenIs(token.RPAREN) {
+		expr.Rparen = p.curToken
+	}
 	return expr
 }
 
@@ -478,18 +1009,50 @@ func (p *Parser) parsePairList(end token.TokenType) map[ast.Expression]ast.Expre
 	return m
 }
 
+// parseIndexExpression parses left[index], or the slice left[low:high]
+// when there is a colon.
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	expr := &ast.IndexExpression{Token: p.curToken, Left: left}
+	tok := p.curToken
 	p.nextToken()
-	expr.Index = p.parseExpression(LOWEST)
+
+	var index ast.Expression
+	if !p.curTokenIs(token.COLON) {
+		index = p.parseExpression(LOWEST)
+		if !p.peekTokenIs(token.COLON) {
+			expr := &ast.IndexExpression{Token: tok, Left: left, Index: index}
+			if !p.expectPeek(token.RBRACKET) {
+				return p.badExpression(expr.Token)
+			}
+			expr.Rbracket = p.curToken
+			return expr
+		}
+		p.nextToken()
+	}
+
+	expr := &ast.SliceExpression{Token: tok, Left: left, Low: index}
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		expr.High = p.parseExpression(LOWEST)
+	}
 	if !p.expectPeek(token.RBRACKET) {
-		return nil
+		return p.badExpression(expr.Token)
 	}
+	expr.Rbracket = p.curToken
 	return expr
 }
 
 // Useful functions.
 
+// badExpression returns a placeholder for an expression that couldn't be
+// parsed, spanning from the start token up to the current one.
+func (p *Parser) badExpression(start token.Token) ast.Expression {
+	to := p.curToken.End
+	if to.Offset < start.End.Offset {
+		to = start.End
+	}
+	return &ast.BadExpression{From: start.Pos, To: to}
+}
+
 func (p *Parser) peekPrecedence() int {
 	if p, ok := precedences[p.peekToken.Type]; ok {
 		return p
@@ -507,16 +1070,53 @@ func (p *Parser) curPrecedence() int {
 // Functions for repoting parsing errors.
 
 func (p *Parser) peekError(want token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s",
-		want, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	d := p.errorf(p.peekToken, diagnostic.UnexpectedToken,
+		"expected next token to be %s, got %s", want, p.peekToken.Type)
+	d.Expected = []token.TokenType{want}
+	d.Found = p.peekToken.Type
+}
+
+func (p *Parser) noPrefixParseFnError(tok token.Token) {
+	// Illegal tokens reported by the lexer need no other error, but the
+	// parser still has to recover from them.
+	if tok.Type == token.ILLEGAL && p.lexErrorAt(tok.Pos) {
+		if !p.panicking {
+			p.panicking = true
+			p.errToken = tok
+		}
+		return
+	}
+
+	d := p.errorf(tok, diagnostic.ExpectedExpression,
+		"no prefix parse function found for %s", tok.Type)
+	d.Found = tok.Type
 }
 
-func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function found for %s", t)
-	p.errors = append(p.errors, msg)
+// lexErrorAt reports whether the lexer reported an error at pos.
+func (p *Parser) lexErrorAt(pos token.Position) bool {
+	for _, d := range p.l.Errors() {
+		if d.Pos == pos {
+			return true
+		}
+	}
+	return false
 }
 
-func (p *Parser) errorf(format string, args ...interface{}) {
-	p.errors = append(p.errors, fmt.Sprintf(format, args...))
+// errorf reports an error spanning the given token and returns the
+// diagnostic so callers can fill in the rest of it. While panicking the
+// diagnostic is returned but not reported.
+func (p *Parser) errorf(tok token.Token, code diagnostic.Code, format string, args ...interface{}) *diagnostic.Diagnostic {
+	d := &diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Pos:      tok.Pos,
+		End:      tok.End,
+	}
+	if !p.panicking {
+		p.errors = append(p.errors, d)
+		p.panicking = true
+		p.errToken = tok
+	}
+	return d
 }
@@ -2,10 +2,13 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/danielrs/monkey/ast"
+	"github.com/danielrs/monkey/diagnostic"
 	"github.com/danielrs/monkey/lexer"
+	"github.com/danielrs/monkey/token"
 )
 
 func TestLetStatements(t *testing.T) {
@@ -44,6 +47,35 @@ func TestLetStatements(t *testing.T) {
 
 }
 
+func TestConstStatements(t *testing.T) {
+	input := "const x = 5; let y = x;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements, got=%d",
+			len(program.Statements))
+	}
+
+	for i, want := range []bool{true, false} {
+		stmt, ok := program.Statements[i].(*ast.LetStatement)
+		if !ok {
+			castError(t, program.Statements[i], "*ast.LetStatement")
+			t.FailNow()
+		}
+		if stmt.IsConst() != want {
+			t.Errorf("statements[%d].IsConst() is %t, want %t", i, stmt.IsConst(), want)
+		}
+	}
+
+	if got := program.String(); got != "const x = 5;let y = x;" {
+		t.Errorf("program.String() is %q", got)
+	}
+}
+
 func testLetStatement(t *testing.T, s ast.Statement, identifier string) bool {
 	letstmt, ok := s.(*ast.LetStatement)
 	if !ok {
@@ -175,6 +207,44 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"0.5;", 0.5},
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement, got=%d",
+				len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			castError(t, program.Statements[0], "*ast.ExpressionStatement")
+		}
+
+		fl, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			castError(t, stmt.Expression, "*ast.FloatLiteral")
+			continue
+		}
+		if fl.Value != tt.expected {
+			t.Errorf("fl.Value is %g, want %g", fl.Value, tt.expected)
+		}
+	}
+}
+
 func TestBooleanLiteralExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -205,6 +275,8 @@ func TestStringLiteralExpression(t *testing.T) {
 	}{
 		{`"foobar";`, "foobar"},
 		{`"foo bar";`, "foo bar"},
+		{`"tab\there";`, "tab\there"},
+		{"`raw\\n`;", `raw\n`},
 	}
 
 	for _, tt := range tests {
@@ -221,6 +293,43 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestInterpolatedString(t *testing.T) {
+	tests := []struct {
+		input    string
+		parts    int
+		expected string
+	}{
+		{`"a${b}c"`, 3, `"a${b}c"`},
+		{`"${1 + 2}"`, 3, `"${(1 + 2)}"`},
+		{`"x: ${x}, y: ${"${y}"}"`, 5, `"x: ${x}, y: ${"${y}"}"`},
+		{`"\${a} ${a}\n"`, 3, `"\${a} ${a}\n"`},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+		if !ok {
+			castError(t, program.Statements[0], "*ast.ExpressionStatement")
+			continue
+		}
+		str, ok := stmt.Expression.(*ast.InterpolatedString)
+		if !ok {
+			castError(t, stmt.Expression, "*ast.InterpolatedString")
+			continue
+		}
+		if len(str.Parts) != tt.parts {
+			t.Errorf("%s has %d parts, want %d", tt.input, len(str.Parts), tt.parts)
+		}
+		if str.String() != tt.expected {
+			t.Errorf("%s is %s, want %s", tt.input, str.String(), tt.expected)
+		}
+	}
+}
+
 func TestPrefixExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -345,6 +454,22 @@ func TestOperatorPrecedence(t *testing.T) {
 		input    string
 		expected string
 	}{
+		{
+			"a <= b == c >= d",
+			"((a <= b) == (c >= d))",
+		},
+		{
+			"a += b * c",
+			"(a += (b * c))",
+		},
+		{
+			"a -= b += c || d",
+			"(a -= (b += (c || d)))",
+		},
+		{
+			"a = b = c + d",
+			"(a = (b = (c + d)))",
+		},
 		{
 			"-a * b",
 			"((-a) * b)",
@@ -512,6 +637,94 @@ func TestIfExpression(t *testing.T) {
 	}
 }
 
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < y) { x += 1 }", "while(x < y) (x += 1)"},
+		{"while (true) { break; }", "whiletrue break;"},
+		{"for (x in xs) { print(x) }", "for (x in xs) print(x)"},
+		{"for (c in \"ab\" + s) { continue }", `for (c in ("ab" + s)) continue;`},
+		{"for (x in xs) { while (x) { break } continue }", "for (x in xs) whilex break;continue;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement, got=%d",
+				len(program.Statements))
+		}
+
+		switch stmt := program.Statements[0].(type) {
+		case *ast.WhileStatement:
+		case *ast.ForStatement:
+			if stmt.Variable.Value != "x" && stmt.Variable.Value != "c" {
+				t.Errorf("stmt.Variable is %s", stmt.Variable)
+			}
+		default:
+			castError(t, stmt, "*ast.WhileStatement or *ast.ForStatement")
+			t.FailNow()
+		}
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q is %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		bindings []string
+	}{
+		{`match (x) { 1 => "one", -2.5 => "minus", "s" => 3, true => 4 }`,
+			`matchx {1 => "one", (-2.5) => "minus", "s" => 3, true => 4}`, nil},
+		{"match (x + 1) { n if n > 1 => n, _ => 0, }",
+			"match(x + 1) {n if (n > 1) => n, _ => 0}", []string{"n"}},
+		{"match (xs) { [] => 0, [a, _, [b]] => a, [h, ...t] => h }",
+			"matchxs {[] => 0, [a, _, [b]] => a, [h, ...t] => h}", []string{"a", "b"}},
+		{`match (h) { {"type": t, 1: [x, ..._]} => t, {} => 1 }`,
+			`matchh {{"type": t, 1: [x, ..._]} => t, {} => 1}`, []string{"t", "x"}},
+		{"match (xs) { [h, ...t] => h, _ => 0 }",
+			"matchxs {[h, ...t] => h, _ => 0}", []string{"h", "t"}},
+		{"match (x) { }", "matchx {}", nil},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		expr, ok := stmt.Expression.(*ast.MatchExpression)
+		if !ok {
+			castError(t, stmt.Expression, "*ast.MatchExpression")
+			t.FailNow()
+		}
+
+		if got := expr.String(); got != tt.expected {
+			t.Errorf("%q is %q, want %q", tt.input, got, tt.expected)
+		}
+
+		if tt.bindings != nil {
+			var names []string
+			for _, name := range ast.Bindings(expr.Arms[len(expr.Arms)-2].Pattern) {
+				names = append(names, name.Value)
+			}
+			if strings.Join(names, " ") != strings.Join(tt.bindings, " ") {
+				t.Errorf("%q binds %v, want %v", tt.input, names, tt.bindings)
+			}
+		}
+	}
+}
+
 func TestFunctionLiteral(t *testing.T) {
 	tests := []struct {
 		input      string
@@ -630,6 +843,32 @@ func TestParsingIndexExpression(t *testing.T) {
 	}
 }
 
+func TestParsingSliceExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"xs[1:3]", "(xs[1:3])"},
+		{"xs[:-1]", "(xs[:(-1)])"},
+		{"s[2:]", "(s[2:])"},
+		{"xs[:]", "(xs[:])"},
+		{"xs[i + 1:len(xs) - 1]", "(xs[(i + 1):(len(xs) - 1)])"},
+		{"xs[1:][0]", "((xs[1:])[0])"},
+		{"f(xs)[a[0]:b]", "(f(xs)[(a[0]):b])"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q is %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
 func TestHashLiteralExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -687,7 +926,7 @@ func TestEmptyHashLiteralExpression(t *testing.T) {
 		t.FailNow()
 	}
 	if len(hash.Pairs) != 0 {
-		t.Error("len(hash.Pairs) got %d, want %d",
+		t.Errorf("len(hash.Pairs) got %d, want %d",
 			len(hash.Pairs), 0)
 	}
 }
@@ -734,7 +973,7 @@ func TestHashLiteralWithExpressions(t *testing.T) {
 			continue
 		}
 		if len(hash.Pairs) != len(tt.expected) {
-			t.Error("len(hash.Pairs) got %d, want %d",
+			t.Errorf("len(hash.Pairs) got %d, want %d",
 				len(hash.Pairs), len(tt.expected))
 			continue
 		}
@@ -850,6 +1089,269 @@ func TestComment(t *testing.T) {
 	}
 }
 
+func TestNodeSpans(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"foo", "foo"},
+		{"1 + 2 * 3", "1 + 2 * 3"},
+		{"-a", "-a"},
+		{"add(1, 2)", "add(1, 2)"},
+		{"arr[1 + 1]", "arr[1 + 1]"},
+		{"arr[1:2]", "arr[1:2]"},
+		{"arr[:]", "arr[:]"},
+		{"[1, 2, 3]", "[1, 2, 3]"},
+		{`{"one": 1}`, `{"one": 1}`},
+		{`"a${b}c"`, `"a${b}c"`},
+		{"if (x) { y } else { z }", "if (x) { y } else { z }"},
+		{"fn(x) {\n\tx\n}", "fn(x) {\n\tx\n}"},
+		{"let x = 5;", "let x = 5"},
+		{"return a + b;", "return a + b"},
+		{"  foo  ", "foo"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0]
+		got := tt.input[stmt.Pos().Offset:stmt.End().Offset]
+		if got != tt.expected {
+			t.Errorf("span of %q is %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestNodePositions(t *testing.T) {
+	input := "let x = 1;\nlet y = x +\n  z;"
+
+	l := lexer.NewFile("test.monkey", input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[1].(*ast.LetStatement)
+	if !ok {
+		castError(t, program.Statements[1], "*ast.LetStatement")
+		t.FailNow()
+	}
+
+	infix, ok := stmt.Value.(*ast.InfixExpression)
+	if !ok {
+		castError(t, stmt.Value, "*ast.InfixExpression")
+		t.FailNow()
+	}
+
+	if got := infix.Pos().String(); got != "test.monkey:2:9" {
+		t.Errorf("infix.Pos() is %s, want %s", got, "test.monkey:2:9")
+	}
+	if got := infix.Right.Pos().String(); got != "test.monkey:3:3" {
+		t.Errorf("infix.Right.Pos() is %s, want %s", got, "test.monkey:3:3")
+	}
+	if got := infix.End().String(); got != "test.monkey:3:4" {
+		t.Errorf("infix.End() is %s, want %s", got, "test.monkey:3:4")
+	}
+}
+
+func TestParserDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		code     diagnostic.Code
+		message  string
+		position string
+		found    token.TokenType
+	}{
+		{"let x 5;", diagnostic.UnexpectedToken,
+			"expected next token to be =, got INT", "1:7", token.INT},
+		{"let = 5;", diagnostic.UnexpectedToken,
+			"expected next token to be IDENT, got =", "1:5", token.ASSIGN},
+		{"let x = 5 +\n;", diagnostic.ExpectedExpression,
+			"no prefix parse function found for ;", "2:1", token.SEMICOLON},
+		{"1e999", diagnostic.InvalidLiteral,
+			`couldn't parse "1e999" as float`, "1:1", ""},
+		{"let x = \"abc;\nx", diagnostic.UnterminatedString,
+			"string literal not terminated", "1:9", ""},
+		{`let x = "\q" +;`, diagnostic.InvalidEscape,
+			`invalid escape sequence \q`, "1:10", ""},
+		{"1 += 2", diagnostic.InvalidAssignment,
+			"cannot assign to 1", "1:3", ""},
+		{"a + b %= 2", diagnostic.InvalidAssignment,
+			"cannot assign to (a + b)", "1:7", ""},
+		{"f() = 2", diagnostic.InvalidAssignment,
+			"cannot assign to f()", "1:5", ""},
+		{"const = 2", diagnostic.UnexpectedToken,
+			"expected next token to be IDENT, got =", "1:7", token.ASSIGN},
+		{"break", diagnostic.InvalidLoopControl,
+			"break is not in a loop", "1:1", ""},
+		{"while (true) { fn() { continue } }", diagnostic.InvalidLoopControl,
+			"continue is not in a loop", "1:23", ""},
+		{"while (c) { let y = if (c) { break } }", diagnostic.InvalidLoopControl,
+			"break cannot leave an expression whose value is used", "1:30", ""},
+		{"for (x in xs) { [x, if (c) { break } else { 0 }] }", diagnostic.InvalidLoopControl,
+			"break cannot leave an expression whose value is used", "1:30", ""},
+		{"while (c) { if (c) { continue } + 1 }", diagnostic.InvalidLoopControl,
+			"continue cannot leave an expression whose value is used", "1:22", ""},
+		{"while (c) { x = if (c) { if (d) { break } } }", diagnostic.InvalidLoopControl,
+			"break cannot leave an expression whose value is used", "1:35", ""},
+		{"while (c) { return if (c) { break } }", diagnostic.InvalidLoopControl,
+			"break cannot leave an expression whose value is used", "1:29", ""},
+		{"for (1 in xs) {}", diagnostic.UnexpectedToken,
+			"expected next token to be IDENT, got INT", "1:6", token.INT},
+		{"for (x of xs) {}", diagnostic.UnexpectedToken,
+			"expected next token to be IN, got IDENT", "1:8", token.IDENT},
+		{"match (x) { y + 1 => 2 }", diagnostic.UnexpectedToken,
+			"expected next token to be =>, got +", "1:15", token.PLUS},
+		{"match (x) { f() => 2 }", diagnostic.UnexpectedToken,
+			"expected next token to be =>, got (", "1:14", token.LPAREN},
+		{"match (x) { -a => 2 }", diagnostic.InvalidPattern,
+			"expected pattern, got -", "1:13", token.MINUS},
+		{"match (x) { [...t, a] => 2 }", diagnostic.InvalidPattern,
+			"...t must be the last element of the pattern", "1:18", ""},
+		{"match (x) { {k: v} => 2 }", diagnostic.InvalidPattern,
+			"expected pattern, got IDENT", "1:14", token.IDENT},
+		{"match (x) { 1 => 2 3 => 4 }", diagnostic.UnexpectedToken,
+			"expected next token to be ,, got INT", "1:20", token.INT},
+		{"xs[1:2:3]", diagnostic.UnexpectedToken,
+			"expected next token to be ], got :", "1:7", token.COLON},
+		{"xs[1:2", diagnostic.UnexpectedToken,
+			"expected next token to be ], got EOF", "1:7", token.EOF},
+		{`"a${}b"`, diagnostic.ExpectedExpression,
+			"empty expression in string interpolation", "1:5", ""},
+		{`"a${1 2}b"`, diagnostic.UnexpectedToken,
+			"expected } to close interpolated expression, got INT", "1:7", token.INT},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("no errors for %q", tt.input)
+			continue
+		}
+
+		d := errors[0]
+		if d.Severity != diagnostic.Error {
+			t.Errorf("d.Severity is %s, want %s", d.Severity, diagnostic.Error)
+		}
+		if d.Code != tt.code {
+			t.Errorf("d.Code is %s, want %s", d.Code, tt.code)
+		}
+		if d.Message != tt.message {
+			t.Errorf("d.Message is %q, want %q", d.Message, tt.message)
+		}
+		if d.Pos.String() != tt.position {
+			t.Errorf("d.Pos is %s, want %s", d.Pos, tt.position)
+		}
+		if d.Found != tt.found {
+			t.Errorf("d.Found is %q, want %q", d.Found, tt.found)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input      string
+		errors     []string // positions of the expected errors
+		statements []string // types of the top-level statements
+	}{
+		{
+			"let x 5; let y = 10; y",
+			[]string{"1:7"},
+			[]string{"*ast.BadStatement", "*ast.LetStatement", "*ast.ExpressionStatement"},
+		},
+		{
+			"let x = (1 + 2\nlet y = 3 +;\nlet z = 4;",
+			[]string{"2:1", "2:12"},
+			[]string{"*ast.LetStatement", "*ast.LetStatement", "*ast.LetStatement"},
+		},
+		{
+			"foo(1, 2 3 4 5 6); bar",
+			[]string{"1:10"},
+			[]string{"*ast.ExpressionStatement", "*ast.ExpressionStatement"},
+		},
+		{
+			"let f = fn(x) { let = 1; x + ; x }; f(1)",
+			[]string{"1:21", "1:30"},
+			[]string{"*ast.LetStatement", "*ast.ExpressionStatement"},
+		},
+		{
+			"if (x { let a = 1; } let b = 2;",
+			[]string{"1:7"},
+			[]string{"*ast.ExpressionStatement", "*ast.LetStatement"},
+		},
+		{
+			"} } let a = 1;",
+			[]string{"1:1", "1:3"},
+			[]string{"*ast.ExpressionStatement", "*ast.ExpressionStatement", "*ast.LetStatement"},
+		},
+		{
+			"let f = fn() { 1",
+			[]string{"1:17"},
+			[]string{"*ast.LetStatement"},
+		},
+		{
+			"while (x { 1 } let b = 2;",
+			[]string{"1:10"},
+			[]string{"*ast.BadStatement", "*ast.LetStatement"},
+		},
+		{
+			"if (x) { break; x } let b = 2;",
+			[]string{"1:10"},
+			[]string{"*ast.ExpressionStatement", "*ast.LetStatement"},
+		},
+		{
+			"[1, ;, 3]; let",
+			[]string{"1:5", "1:15"},
+			[]string{"*ast.ExpressionStatement", "*ast.BadStatement"},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := p.Errors()
+		if len(errors) != len(tt.errors) {
+			t.Errorf("%q has %d errors, want %d: %v",
+				tt.input, len(errors), len(tt.errors), errors)
+			continue
+		}
+		for i, d := range errors {
+			if d.Pos.String() != tt.errors[i] {
+				t.Errorf("%q error %d at %s, want %s",
+					tt.input, i, d.Pos, tt.errors[i])
+			}
+		}
+
+		if len(program.Statements) != len(tt.statements) {
+			t.Errorf("%q has %d statements, want %d: %s",
+				tt.input, len(program.Statements), len(tt.statements), program)
+			continue
+		}
+		for i, stmt := range program.Statements {
+			if got := fmt.Sprintf("%T", stmt); got != tt.statements[i] {
+				t.Errorf("%q statement %d is %s, want %s",
+					tt.input, i, got, tt.statements[i])
+			}
+		}
+
+		// The best-effort program can be walked and printed safely.
+		ast.Inspect(program, func(n ast.Node) bool {
+			if n.End().Offset < n.Pos().Offset {
+				t.Errorf("%q node %s ends before it starts", tt.input, n)
+			}
+			return true
+		})
+	}
+}
+
 // Helper functions for testing.
 
 func testIdentifierExpression(t *testing.T, expr ast.Expression, value string) bool {
@@ -898,7 +1400,7 @@ func testBooleanLiteralExpression(t *testing.T, expr ast.Expression, value bool)
 		return false
 	}
 	if boolean.TokenLiteral() != fmt.Sprintf("%t", value) {
-		t.Errorf("boolean.TokenLiteral() is %t, want %t",
+		t.Errorf("boolean.TokenLiteral() is %q, want %t",
 			boolean.TokenLiteral(), value)
 		return false
 	}
@@ -5,18 +5,29 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/danielrs/monkey/ast"
+	"github.com/danielrs/monkey/compiler"
+	"github.com/danielrs/monkey/diagnostic"
 	"github.com/danielrs/monkey/evaluator"
 	"github.com/danielrs/monkey/lexer"
 	"github.com/danielrs/monkey/object"
 	"github.com/danielrs/monkey/parser"
+	"github.com/danielrs/monkey/vm"
 )
 
 const PROMPT = ">> "
 
-// Starts is the REPL loop that goes forever.
-func Start(in io.Reader, out io.Writer) {
+// Engines that can run the code.
+const (
+	EngineEval = "eval" // tree-walking evaluator
+	EngineVM   = "vm"   // bytecode compiler and virtual machine
+)
+
+// Starts is the REPL loop that goes forever. The engine is one of EngineEval
+// or EngineVM, and strict makes indexes out of range errors.
+func Start(in io.Reader, out io.Writer, engine string, strict bool) {
 	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+	run := newRunner(engine, strict, out)
 	for {
 		fmt.Fprintf(out, PROMPT)
 
@@ -30,11 +41,11 @@ func Start(in io.Reader, out io.Writer) {
 		program := p.ParseProgram()
 
 		if len(p.Errors()) != 0 {
-			printParserErrors(out, p.Errors())
+			printParserErrors(out, line, p.Errors())
 			continue
 		}
 
-		evaluated := evaluator.Eval(env, program)
+		evaluated := run(program)
 		if evaluated != nil {
 			io.WriteString(out, evaluated.Inspect())
 			io.WriteString(out, "\n")
@@ -42,26 +53,88 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
-func Run(str string, out io.Writer) {
-	env := object.NewEnvironment()
+// newRunner returns a function that runs programs with the given engine,
+// keeping the bindings made by each of them. Programs print to out.
+func newRunner(engine string, strict bool, out io.Writer) func(*ast.Program) object.Object {
+	if engine != EngineVM {
+		env := object.NewEnvironment()
+		e := evaluator.New()
+		e.SetStreams(nil, out, out)
+		e.SetStrict(strict)
+		return func(program *ast.Program) object.Object {
+			return e.Eval(env, program)
+		}
+	}
+
+	symbolTable := compiler.NewSymbolTable()
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+	return func(program *ast.Program) object.Object {
+		c := compiler.NewWithState(symbolTable, constants)
+		if err := c.Compile(program); err != nil {
+			return &object.Error{Message: err.Error()}
+		}
+		bytecode := c.Bytecode()
+		constants = bytecode.Constants
+		machine := vm.NewWithGlobals(bytecode, globals)
+		machine.SetStreams(nil, out, out)
+		machine.SetStrict(strict)
+		return machine.Run()
+	}
+}
 
-	l := lexer.New(str)
+// Run evaluates the given source, reporting positions relative to filename.
+// Diagnostics, errors and the result of the program all go to out.
+func Run(filename, str string, out io.Writer) {
+	RunFile(filename, str, EngineEval, false, out, out)
+}
+
+// RunFile runs the given source with engine, in strict mode if asked to,
+// reporting positions relative to filename. Diagnostics and runtime errors
+// go to errOut and the result of the program to out. Returns false if the
+// program failed.
+func RunFile(filename, src, engine string, strict bool, out, errOut io.Writer) bool {
+	l := lexer.NewFile(filename, src)
 	p := parser.New(l)
 	program := p.ParseProgram()
 	if len(p.Errors()) != 0 {
-		printParserErrors(out, p.Errors())
-		return
+		for _, d := range p.Errors() {
+			io.WriteString(errOut, d.Render(src))
+		}
+		return false
 	}
 
-	evaluated := evaluator.Eval(env, program)
+	var evaluated object.Object
+	if engine == EngineVM {
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			fmt.Fprintln(errOut, err)
+			return false
+		}
+		machine := vm.New(c.Bytecode())
+		machine.SetStreams(nil, out, errOut)
+		machine.SetStrict(strict)
+		evaluated = machine.Run()
+	} else {
+		e := evaluator.New()
+		e.SetStreams(nil, out, errOut)
+		e.SetStrict(strict)
+		evaluated = e.Eval(object.NewEnvironment(), program)
+	}
+
+	if err, ok := evaluated.(*object.Error); ok {
+		io.WriteString(errOut, err.StackTrace())
+		return false
+	}
 	if evaluated != nil {
 		io.WriteString(out, evaluated.Inspect())
 		io.WriteString(out, "\n")
 	}
+	return true
 }
 
-func printParserErrors(out io.Writer, errors []string) {
-	for _, msg := range errors {
-		io.WriteString(out, "\t"+msg+"\n")
+func printParserErrors(out io.Writer, src string, errors []*diagnostic.Diagnostic) {
+	for _, d := range errors {
+		io.WriteString(out, d.Render(src))
 	}
 }
@@ -1,28 +1,40 @@
 package token
 
-import "fmt"
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
 
 // Character and Literal types are useful while lexing for
-// treating single characters and strings as the same.
+// treating single characters and strings as the same. A Character is a
+// Unicode code point.
 
-type Character byte
+type Character rune
 
 func (c Character) String() string {
 	return string(c)
 }
 
+// IsLetter reports whether c can be part of an identifier, which is made
+// of Unicode letters (category L, as in unicode.IsLetter) and underscores.
 func (c Character) IsLetter() bool {
-	ch := byte(c)
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+	ch := rune(c)
+	if ch < utf8.RuneSelf {
+		return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+	}
+	return unicode.IsLetter(ch)
 }
 
+// IsDigit reports whether c is an ASCII digit; numbers are written with
+// those only.
 func (c Character) IsDigit() bool {
-	ch := byte(c)
+	ch := rune(c)
 	return '0' <= ch && ch <= '9'
 }
 
-func (c Character) IsChar(ch byte) bool {
-	return byte(c) == ch
+func (c Character) IsChar(ch rune) bool {
+	return rune(c) == ch
 }
 
 type Literal string
@@ -43,8 +55,15 @@ const (
 	// Identifiers + litlerals
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
+	// Parts of an interpolated string like "a${x}b${y}c", which is lexed
+	// as INTERP_START "a", x, INTERP_MID "b", y, INTERP_END "c".
+	INTERP_START = "INTERP_START"
+	INTERP_MID   = "INTERP_MID"
+	INTERP_END   = "INTERP_END"
+
 	// Operators.
 	ASSIGN   = "="
 	PLUS     = "+"
@@ -54,9 +73,18 @@ const (
 	MOD      = "%"
 	BANG     = "!"
 
+	// Compound assignment operators.
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	MOD_ASSIGN      = "%="
+
 	// Relational operators.
 	LT     = "<"
 	GT     = ">"
+	LT_EQ  = "<="
+	GT_EQ  = ">="
 	EQ     = "=="
 	NOT_EQ = "!="
 
@@ -68,6 +96,8 @@ const (
 	COMMA     = ","
 	SEMICOLON = ";"
 	COLON     = ":"
+	ARROW     = "=>"
+	ELLIPSIS  = "..."
 
 	LPAREN   = "("
 	RPAREN   = ")"
@@ -79,37 +109,86 @@ const (
 	// Keywords.
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	CONST    = "CONST"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	MATCH    = "MATCH"
 
 	// Comment
 	COMMENT = "//"
 )
 
+// Position describes a location in the source. Offsets are counted in bytes
+// starting at 0, while lines and columns start at 1.
+
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// IsValid reports whether the position has been set.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position in the usual "file:line:column" form, leaving
+// out whatever parts are unknown.
+func (p Position) String() string {
+	s := p.Filename
+	if p.IsValid() {
+		if s != "" {
+			s += ":"
+		}
+		s += fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	if s == "" {
+		s = "-"
+	}
+	return s
+}
+
 // A token can be create using the make function (not new since the returned
-// value is not a pointer).
+// value is not a pointer). Pos is the position of the first character of the
+// token and End the position immediately after its last one; both are filled
+// in by the lexer.
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position
+	End     Position
 }
 
 func Make(t TokenType, literal fmt.Stringer) Token {
-	return Token{t, literal.String()}
+	return Token{Type: t, Literal: literal.String()}
 }
 
 // keywords is a map of reserved keywords in the language.
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"const":    CONST,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"match":    MATCH,
 }
 
 // LookupIdent checks if the given identiier is a keyword.
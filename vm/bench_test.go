@@ -0,0 +1,54 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/danielrs/monkey/compiler"
+	"github.com/danielrs/monkey/evaluator"
+	"github.com/danielrs/monkey/object"
+)
+
+var benchmarks = []struct {
+	name  string
+	input string
+}{
+	{
+		"fib",
+		`let fib = fn(n) {
+			if (n < 2) { n } else { fib(n - 1) + fib(n - 2) }
+		};
+		fib(20)`,
+	},
+	{
+		"fold",
+		`let fold = fn(arr, acc, f) {
+			if (len(arr) == 0) { acc } else { fold(tail(arr), f(acc, head(arr)), f) }
+		};
+		let build = fn(n, arr) {
+			if (n == 0) { arr } else { build(n - 1, push(arr, n)) }
+		};
+		fold(build(500, []), 0, fn(a, b) { a + b })`,
+	},
+}
+
+func BenchmarkEngines(b *testing.B) {
+	for _, bm := range benchmarks {
+		program := parse(bm.input)
+
+		b.Run(bm.name+"/eval", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				evaluator.Eval(object.NewEnvironment(), program)
+			}
+		})
+
+		b.Run(bm.name+"/vm", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := compiler.New()
+				if err := c.Compile(program); err != nil {
+					b.Fatalf("compiler error: %s", err)
+				}
+				New(c.Bytecode()).Run()
+			}
+		})
+	}
+}
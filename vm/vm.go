@@ -0,0 +1,534 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/danielrs/monkey/ast"
+	"github.com/danielrs/monkey/code"
+	"github.com/danielrs/monkey/compiler"
+	"github.com/danielrs/monkey/evaluator"
+	"github.com/danielrs/monkey/object"
+	"github.com/danielrs/monkey/token"
+)
+
+const (
+	StackSize   = 2048
+	GlobalsSize = 65536
+
+	// MaxDepth is how deep calls to functions can nest, like in the
+	// evaluator.
+	MaxDepth = evaluator.DefaultMaxDepth
+)
+
+var (
+	NULL  = evaluator.NULL
+	TRUE  = evaluator.TRUE
+	FALSE = evaluator.FALSE
+)
+
+// VM runs the bytecode produced by the compiler. Operators, indexing and
+// builtins are shared with the evaluator, so programs give the same
+// results with either of them.
+type VM struct {
+	constants []object.Object
+	globals   []object.Object
+	builtins  evaluator.Builtins
+	strict    bool
+
+	stdin          io.Reader
+	stdout, stderr io.Writer
+
+	stack []object.Object
+	sp    int // stack[sp-1] is the top of the stack
+
+	frames []*frame
+}
+
+// frame is a call to a function; the program itself runs in the first
+// one. The stack of the function starts at base, so returning drops
+// whatever it left there, like the iterators of the loops it was in.
+type frame struct {
+	cl     *object.Closure
+	ip     int
+	locals *object.Locals
+	base   int
+}
+
+// Returns a pointer to a new VM.
+func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithGlobals(bytecode, make([]object.Object, GlobalsSize))
+}
+
+// NewWithGlobals returns a VM that uses the given globals, so they can be
+// kept between runs.
+func NewWithGlobals(bytecode *compiler.Bytecode, globals []object.Object) *VM {
+	main := &object.CompiledFunction{
+		Name:         "main",
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+	}
+
+	return &VM{
+		constants: bytecode.Constants,
+		globals:   globals,
+		builtins:  evaluator.DefaultBuiltins(),
+		stdin:     os.Stdin,
+		stdout:    os.Stdout,
+		stderr:    os.Stderr,
+		stack:     make([]object.Object, StackSize),
+		frames:    []*frame{{cl: &object.Closure{Fn: main}}},
+	}
+}
+
+// SetBuiltins sets the builtin functions available to the program.
+func (vm *VM) SetBuiltins(b evaluator.Builtins) {
+	vm.builtins = b
+}
+
+// SetStrict sets whether indexes and slice bounds out of range are errors,
+// rather than giving null or stopping at the ends.
+func (vm *VM) SetStrict(strict bool) {
+	vm.strict = strict
+}
+
+// SetStreams sets the standard streams used by builtins. Nil arguments
+// leave the stream as it was, which is initially the one of the process.
+func (vm *VM) SetStreams(stdin io.Reader, stdout, stderr io.Writer) {
+	if stdin != nil {
+		vm.stdin = stdin
+	}
+	if stdout != nil {
+		vm.stdout = stdout
+	}
+	if stderr != nil {
+		vm.stderr = stderr
+	}
+}
+
+// The VM is the runtime of the builtins it calls.
+
+func (vm *VM) Stdin() io.Reader  { return vm.stdin }
+func (vm *VM) Stdout() io.Writer { return vm.stdout }
+func (vm *VM) Stderr() io.Writer { return vm.stderr }
+
+// Alloc never fails, as the VM has no limits.
+func (vm *VM) Alloc(n int64) *object.Error { return nil }
+
+func (vm *VM) Call(fn object.Object, args ...object.Object) object.Object {
+	depth, sp := len(vm.frames), vm.sp
+
+	vm.push(fn)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	if err := vm.call(len(args), false); err != nil {
+		vm.sp = sp
+		return err
+	}
+	if len(vm.frames) == depth {
+		// Builtins are done already.
+		return vm.pop()
+	}
+
+	result := vm.run(depth)
+	if _, ok := result.(*object.Error); ok {
+		vm.frames = vm.frames[:depth]
+		vm.sp = sp
+	}
+	return result
+}
+
+// Run executes the program and returns its value, which is nil for empty
+// programs. Runtime errors are returned as *object.Error with their
+// position and call stack filled in.
+func (vm *VM) Run() object.Object {
+	return vm.run(0)
+}
+
+// run executes instructions until the program ends or, when called back
+// by a builtin, until the frames return to the given depth.
+func (vm *VM) run(depth int) object.Object {
+	for {
+		f := vm.frames[len(vm.frames)-1]
+		ins := f.cl.Fn.Instructions
+		if f.ip >= len(ins) {
+			break
+		}
+
+		op := code.Opcode(ins[f.ip])
+		f.ip++
+
+		var err *object.Error
+
+		switch op {
+		case code.OpConstant:
+			idx := code.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			vm.push(vm.constants[idx])
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpDup:
+			vm.push(vm.stack[vm.sp-1])
+
+		case code.OpTrue:
+			vm.push(TRUE)
+
+		case code.OpFalse:
+			vm.push(FALSE)
+
+		case code.OpNull:
+			vm.push(NULL)
+
+		case code.OpArray:
+			n := int(code.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			elements := make([]object.Object, n)
+			copy(elements, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			vm.push(&object.Array{Elements: elements})
+
+		case code.OpHash:
+			n := int(code.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			var hash object.Object
+			hash, err = vm.buildHash(vm.stack[vm.sp-2*n : vm.sp])
+			vm.sp -= 2 * n
+			if err == nil {
+				vm.push(hash)
+			}
+
+		case code.OpInterpolate:
+			n := int(code.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			str := evaluator.Interpolate(vm.stack[vm.sp-n : vm.sp]...)
+			vm.sp -= n
+			vm.push(str)
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpEqual, code.OpNotEqual, code.OpLessThan, code.OpGreaterThan,
+			code.OpLessEqual, code.OpGreaterEqual:
+			right := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.EvalInfix(infixOperators[op], left, right))
+
+		case code.OpMinus:
+			err = vm.pushResult(evaluator.EvalPrefix("-", vm.pop()))
+
+		case code.OpBang:
+			err = vm.pushResult(evaluator.EvalPrefix("!", vm.pop()))
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.EvalIndex(left, index, vm.strict))
+
+		case code.OpSlice:
+			high := vm.pop()
+			low := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.EvalSlice(left, low, high, vm.strict))
+
+		case code.OpJump:
+			f.ip = int(code.ReadUint16(ins[f.ip:]))
+
+		case code.OpJumpNotTruthy:
+			target := int(code.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			if !evaluator.IsTruthy(vm.pop()) {
+				f.ip = target
+			}
+
+		case code.OpAnd, code.OpOr:
+			target := int(code.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			if evaluator.IsTruthy(vm.stack[vm.sp-1]) == (op == code.OpOr) {
+				f.ip = target
+			} else {
+				vm.pop()
+			}
+
+		case code.OpIter:
+			err = vm.pushResult(evaluator.Iterate(vm.pop()))
+
+		case code.OpIterNext:
+			target := int(code.ReadUint16(ins[f.ip:]))
+			f.ip += 2
+			if el, ok := vm.stack[vm.sp-1].(*evaluator.Iterator).Next(); ok {
+				vm.push(el)
+			} else {
+				vm.pop()
+				f.ip = target
+			}
+
+		case code.OpMatch:
+			idx := code.ReadUint16(ins[f.ip:])
+			target := int(code.ReadUint16(ins[f.ip+2:]))
+			f.ip += 4
+			pattern := vm.constants[idx].(*object.Pattern).Pattern
+			if values, ok := evaluator.Match(pattern, vm.stack[vm.sp-1]); ok {
+				for i := len(values) - 1; i >= 0; i-- {
+					vm.push(values[i])
+				}
+			} else {
+				f.ip = target
+			}
+
+		case code.OpNoMatch:
+			err = evaluator.NoMatch(vm.pop())
+
+		case code.OpGetGlobal:
+			idx := code.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			err = vm.pushVariable(f, vm.globals[idx])
+
+		case code.OpSetGlobal:
+			idx := code.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			vm.globals[idx] = vm.pop()
+
+		case code.OpEnterScope:
+			n := int(code.ReadUint8(ins[f.ip:]))
+			f.ip++
+			f.locals = &object.Locals{Slots: make([]object.Object, n), Parent: f.locals}
+
+		case code.OpLeaveScope:
+			f.locals = f.locals.Parent
+
+		case code.OpGetLocal:
+			idx := code.ReadUint8(ins[f.ip:])
+			f.ip++
+			err = vm.pushVariable(f, f.locals.Slots[idx])
+
+		case code.OpSetLocal:
+			idx := code.ReadUint8(ins[f.ip:])
+			f.ip++
+			f.locals.Slots[idx] = vm.pop()
+
+		case code.OpGetOuter:
+			depth := code.ReadUint8(ins[f.ip:])
+			idx := code.ReadUint8(ins[f.ip+1:])
+			f.ip += 2
+			locals := f.locals
+			for i := uint8(0); i < depth; i++ {
+				locals = locals.Parent
+			}
+			err = vm.pushVariable(f, locals.Slots[idx])
+
+		case code.OpSetOuter:
+			depth := code.ReadUint8(ins[f.ip:])
+			idx := code.ReadUint8(ins[f.ip+1:])
+			f.ip += 2
+			locals := f.locals
+			for i := uint8(0); i < depth; i++ {
+				locals = locals.Parent
+			}
+			locals.Slots[idx] = vm.pop()
+
+		case code.OpGetBuiltin:
+			idx := code.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			name := vm.constants[idx].(*object.String).Value
+			if builtin, ok := vm.builtins[name]; ok {
+				vm.push(builtin)
+			} else {
+				err = newError("identifier not found: %s", name)
+			}
+
+		case code.OpClosure:
+			idx := code.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			fn := vm.constants[idx].(*object.CompiledFunction)
+			vm.push(&object.Closure{Fn: fn, Parent: f.locals})
+
+		case code.OpCall, code.OpTailCall:
+			numArgs := int(code.ReadUint8(ins[f.ip:]))
+			f.ip++
+			err = vm.call(numArgs, op == code.OpTailCall)
+
+		case code.OpError:
+			idx := code.ReadUint16(ins[f.ip:])
+			f.ip += 2
+			err = newError("%s", vm.constants[idx].(*object.String).Value)
+
+		case code.OpReturnValue:
+			value := vm.pop()
+			if len(vm.frames) == 1 {
+				return value
+			}
+			vm.sp = f.base
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) == depth {
+				return value
+			}
+			vm.push(value)
+
+		default:
+			def, _ := code.Lookup(byte(op))
+			name := "unknown"
+			if def != nil {
+				name = def.Name
+			}
+			err = newError("unsupported opcode %s", name)
+		}
+
+		if err != nil {
+			return vm.stamp(err)
+		}
+	}
+
+	if vm.sp == 0 {
+		return nil
+	}
+	return vm.stack[vm.sp-1]
+}
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLessThan:     "<",
+	code.OpGreaterThan:  ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+}
+
+// call calls the function below the numArgs arguments on top of the stack.
+// Tail calls to functions replace the frame making them, which would only
+// return their result.
+func (vm *VM) call(numArgs int, tail bool) *object.Error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	args := vm.stack[vm.sp-numArgs : vm.sp]
+
+	switch fn := callee.(type) {
+	case *object.Closure:
+		if numArgs != fn.Fn.NumParameters {
+			return newError("argument mismatch: got %d, want %d",
+				numArgs, fn.Fn.NumParameters)
+		}
+		// The first frame is the program rather than a function.
+		depth := len(vm.frames) - 1
+		if tail {
+			depth--
+		}
+		if depth >= MaxDepth {
+			return &object.Error{
+				Kind:    object.DepthLimitError,
+				Message: fmt.Sprintf("call depth limit exceeded: %d", MaxDepth),
+			}
+		}
+
+		locals := &object.Locals{
+			Slots:  make([]object.Object, fn.Fn.NumLocals),
+			Parent: fn.Parent,
+		}
+		copy(locals.Slots, args)
+		vm.sp -= numArgs + 1
+		if tail {
+			vm.sp = vm.frames[len(vm.frames)-1].base
+			vm.frames = vm.frames[:len(vm.frames)-1]
+		}
+		vm.frames = append(vm.frames, &frame{cl: fn, locals: locals, base: vm.sp})
+		return nil
+
+	case *object.Builtin:
+		// Builtins may keep their arguments, so they get a copy.
+		result := evaluator.CallBuiltin(vm, fn, append([]object.Object(nil), args...)...)
+		vm.sp -= numArgs + 1
+		return vm.pushResult(result)
+	}
+
+	return newError("not a function: %s", callee.Type())
+}
+
+func (vm *VM) buildHash(kvs []object.Object) (object.Object, *object.Error) {
+	pairs := make(map[object.HashKey]object.HashPair, len(kvs)/2)
+	for i := 0; i < len(kvs); i += 2 {
+		key, value := kvs[i], kvs[i+1]
+		hashed, ok := object.HashKeyOf(key)
+		if !ok {
+			return nil, newError("unusable as hash key: %s", key.Type())
+		}
+		pairs[hashed] = object.HashPair{Key: key, Value: value}
+	}
+	return &object.Hash{Pairs: pairs}, nil
+}
+
+// pushVariable pushes the value of a variable, which is nil when it is
+// used before being bound. Until then its name still refers to the builtin
+// it hides, if any, as it does in the evaluator, and it can't be assigned.
+func (vm *VM) pushVariable(f *frame, value object.Object) *object.Error {
+	if value == nil {
+		name := "?"
+		switch node := f.cl.Fn.SourceMap.Lookup(f.ip - 1).(type) {
+		case *ast.Identifier:
+			name = node.Value
+		case *ast.AssignExpression:
+			return newError("cannot assign to undeclared variable: %s", node.Name.Value)
+		}
+		builtin, ok := vm.builtins[name]
+		if !ok {
+			return newError("identifier not found: %s", name)
+		}
+		value = builtin
+	}
+	vm.push(value)
+	return nil
+}
+
+// pushResult pushes the result of an operation, unless it is an error.
+func (vm *VM) pushResult(result object.Object) *object.Error {
+	if err, ok := result.(*object.Error); ok {
+		return err
+	}
+	vm.push(result)
+	return nil
+}
+
+// stamp fills in the position and call stack of err, from the instruction
+// being executed in each frame.
+func (vm *VM) stamp(err *object.Error) *object.Error {
+	if err.Stack != nil {
+		return err
+	}
+
+	err.Stack = make([]object.Frame, 0, len(vm.frames))
+	for i := len(vm.frames) - 1; i >= 0; i-- {
+		f := vm.frames[i]
+		var pos token.Position
+		if node := f.cl.Fn.SourceMap.Lookup(f.ip - 1); node != nil {
+			pos = node.Pos()
+		}
+		err.Stack = append(err.Stack, object.Frame{Function: f.cl.Fn.Name, Pos: pos})
+	}
+	err.Pos = err.Stack[0].Pos
+
+	return err
+}
+
+// Helper functions.
+
+func (vm *VM) push(obj object.Object) {
+	if vm.sp == len(vm.stack) {
+		vm.stack = append(vm.stack, obj)
+	} else {
+		vm.stack[vm.sp] = obj
+	}
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+func newError(format string, args ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, args...)}
+}
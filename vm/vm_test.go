@@ -0,0 +1,407 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/danielrs/monkey/ast"
+	"github.com/danielrs/monkey/compiler"
+	"github.com/danielrs/monkey/evaluator"
+	"github.com/danielrs/monkey/lexer"
+	"github.com/danielrs/monkey/object"
+	"github.com/danielrs/monkey/parser"
+)
+
+// The VM must give the same results as the evaluator, so most tests run
+// programs with both and compare them.
+
+func TestSameAsEvaluator(t *testing.T) {
+	tests := []string{
+		// Literals and operators.
+		"",
+		"5",
+		"-5 + 10 * 2 - 6 / 3 % 4",
+		`"foo" + "bar"`,
+		"1.5 * 2 - 0.25",
+		"1 < 1.5",
+		`[1 <= 2, 2 >= 3, "a" < "b", "b" >= "b", 1.5 <= 1]`,
+		"-0.5",
+		"int(2.5) + float(1)",
+		"9223372036854775807 + 1",
+		"100000000000000000000 / 3 - 33333333333333333333",
+		"-(-9223372036854775807 - 1) > 9223372036854775807",
+		"1 < 2 == true",
+		"!5 != !!true",
+		"false || 5",
+		"true && 5",
+		"false && undefined",
+		"true || undefined",
+		"[1, 2 * 2, 3 + 3]",
+		"[1, 2, 3][1]",
+		"[1, 2, 3][5]",
+		`"héllo"[1] + "\x41\n"`,
+		"`raw\\n`",
+		`let x = 2; "x = ${x}, ${[x, "y"]}, ${x * 1.5}"`,
+		`"${1 + true}"`,
+		`str(fn(x) { x })`,
+		`len("日本語")`,
+		`{"a": 1}["a"]`,
+		`{"a": 1}["b"]`,
+		"{1: true}[1]",
+		// Conditionals.
+		"if (true) { 10 }",
+		"if (false) { 10 }",
+		"if (1 > 2) { 10 } else { 20 }",
+		"if (true) { }",
+		// Bindings.
+		"let a = 5; a",
+		"let a = 5; let b = a * 2; a + b",
+		"let a = 5;",
+		"let a = 1; let a = a + 1; a",
+		// Functions.
+		"fn() { 5 + 10 }()",
+		"fn(a, b) { a + b }(1, 2)",
+		"fn() { return 1; 2 }()",
+		"fn() { if (true) { return 1; } 2 }()",
+		"fn() { }()",
+		"return 1; 2",
+		"let f = fn(x) { x }; f",
+		// Closures.
+		"let add = fn(a) { fn(b) { a + b } }; add(2)(3)",
+		"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)",
+		"let f = fn() { let g = fn() { h() }; let h = fn() { 1 }; g() }; f()",
+		// Assignments.
+		"let a = 1; a += 2; a *= 3; a -= 1; a /= 2; a %= 3; a",
+		`let s = "a"; s += "b"; s`,
+		"let a = 1; let b = 2; a += b += 3; [a, b]",
+		"let a = 1; let f = fn() { a += 1 }; f(); f(); a",
+		"let counter = fn() { let n = 0; fn() { n += 1 } }; let c = counter(); c(); c()",
+		"let f = fn() { let n = 0; let g = fn() { fn() { n += 10 } }; g()(); n }; f()",
+		"let a = 1; a = 2; a",
+		"let a = 1; let b = 2; a = b = 3; [a, b]",
+		"let a = 1; let f = fn() { a = a * 10 }; f(); f(); a",
+		"let f = fn() { let n = 0; let g = fn() { n = n + 1 }; g(); g(); n }; f()",
+		"const a = 1; let a = 2; a = 3; a",
+		"a = 1",
+		"a += 1",
+		"len += 1",
+		"const a = 1; a = 2",
+		"const a = 1; let f = fn() { a = 2 }; f()",
+		`let a = 1; a += "b"`,
+		// Loops.
+		"let i = 0; let s = 0; while (i < 10) { i += 1; if (i % 3 == 0) { continue } if (i > 8) { break } s += i }; [i, s]",
+		"let s = []; for (x in [1, 2, 3]) { s = push(s, x * x) }; s",
+		`let s = ""; for (c in "héllo") { s = c + s }; s`,
+		`let s = []; for (k in {"a": 1, 2: 2, true: 3, 1.5: 4}) { s = push(s, k) }; s`,
+		"let s = 0; for (i in range(1, 100, 7)) { s += i }; [s, i]",
+		"let s = []; for (a in range(3)) { for (b in range(3)) { if (b > a) { break } s = push(s, [a, b]) } }; s",
+		"let s = 0; for (x in range(10)) { if (x > 5) { let y = x * 2; if (y == 14) { break } s += y } else { s += x } }; s",
+		"let s = []; for (x in range(4)) { s = push(s, [x, if (x > 1) { for (y in [7]) { if (y) { break } } 5 } else { 0 }]) }; s",
+		"[100, if (true) { let i = 0; while (true) { i += 1; if (i > 2) { break } } i }]",
+		"let i = 0; let s = 0; while (i < 6) { i += 1; if (i % 2 == 0) { if (i == 4) { continue } s += 10 } s += i }; s",
+		"let f = fn(xs) { for (x in xs) { if (x > 2) { return x } }; -1 }; [f([1, 5, 3]), f([1])]",
+		"let f = fn() { let s = 0; for (x in range(3)) { let g = fn() { s += x }; g() }; s }; f()",
+		"let fs = []; for (i in range(3)) { fs = push(fs, fn() { i }) }; fs[0]()",
+		"let x = 5; for (x in [1, 2]) { }; x",
+		"let f = fn() { let x = 5; for (x in [1, 2]) { let y = x }; [x, y] }; f()",
+		"let f = fn(n) { for (x in range(n)) { let g = fn() { x + n }; if (x == 2) { return g() } } }; f(5)",
+		"const c = 1; for (c in [5, 6]) { }; c = 9; c",
+		"for (x in [1]) { let y = x }; y",
+		"while (false) { }",
+		"for (x in [1]) { x }",
+		"for (x in 5) { }",
+		// Match expressions.
+		`let f = fn(x) { match (x) { 0 => "zero", -1.5 => "neg", "s" => "str", true => "yes", [] => "empty", _ => "other" } }; [f(0), f(-1.5), f("s"), f(true), f([]), f(2)]`,
+		"let f = fn(xs) { match (xs) { [a, [b, c]] => a + b + c, [h, ...t] if h > 0 => t, [_, ...t] => len(t) } }; [f([1, [2, 3]]), f([1, 2, 3]), f([-1, 2, 3])]",
+		`match ({"a": 1, "b": [2, 3]}) { {"a": x, "b": [_, y]} => x + y }`,
+		"let sum = fn(xs, acc) { match (xs) { [] => acc, [h, ...t] => sum(t, acc + h) } }; let xs = []; for (i in range(1000)) { xs = push(xs, i) }; sum(xs, 0)",
+		"let f = fn(n) { match (n) { 0 => 0, n => f(n - 1) } }; f(5)",
+		"match (1) { x => x }; x",
+		"let x = 1; match (2) { x => x }; x",
+		"let x = 1; let f = fn() { match (2) { y => x = y }; x }; [f(), x]",
+		"match (2) { y if false => 1, _ => 0 }; y",
+		"const c = 1; match (2) { c => c }; c = 3",
+		"let fs = []; for (i in range(3)) { fs = push(fs, match (i) { x => fn() { x } }) }; map(fs, fn(f) { f() })",
+		"let f = fn(a) { match (a) { [x, y] => fn(z) { x + y + z + a[0] } } }; f([1, 2])(3)",
+		"match (3) { n => if (true) { let s = 0; for (i in range(10)) { if (i == n) { break }; s += i }; s } }",
+		"match (3) { 1 => 1, 2 => 2 }",
+		"match ([1]) { [x] if x + true => 1 }",
+		// Recursion.
+		"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)",
+		"let f = fn() { let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } }; loop(10) }; f()",
+		// Builtins.
+		`len("four")`,
+		"push([1], 2)",
+		"tail([1, 2, 3])",
+		"let len = fn(x) { 0 }; len([1])",
+		// Equality and ordering.
+		`[[1, 2] == [1, 2], [1] == [1.0], {"a": [1]} == {"a": [1]}, {"a": 1} != {"a": 2}, [] == {}]`,
+		"let f = fn() { 1 }; [f == f, fn() { 1 } == fn() { 1 }, len == len, [f] == [f]]",
+		`[fn() { }() == fn() { }(), fn() { }() == false, 1 == "1", range(3) == range(0, 3)]`,
+		`[[1, 2] < [1, 3], [1] < [1, 0], [2] > [1, 5], [1, "b"] >= [1, "b"], sort([[2], [1, 2], [1]])]`,
+		`[1] < ["a"]`,
+		"{} < {}",
+		// Indexing and slicing.
+		`[[1, 2, 3][-1], [1, 2, 3][-4], "héllo"[-4], "abc"[3]]`,
+		`let xs = [1, 2, 3, 4]; [xs[1:3], xs[:-1], xs[2:], xs[:], xs[3:1], xs[-10:10]]`,
+		`let s = "héllo"; [s[1:3], s[2:], s[:-1], s[9:]]`,
+		"[1][fn() {}():]",
+		"5[1:]",
+		"[1][:1.5]",
+		`let h = {"b": 1, "a": 2}; [keys(h), values(h), entries(h), len(h)]`,
+		`let h = {"a": 1}; [get(set(h, "b", 2), "b"), has(delete(h, "a"), "a"), get(h, "c", 3), len(merge(h, {"d": 4}))]`,
+		`get({}, [])`,
+		"let double = fn(x) { x * 2 }; map(range(4), double)",
+		"filter([1, 2, 3, 4], fn(x) { x % 2 == 0 })",
+		"reduce([1, 2, 3], fn(acc, x) { acc * 10 + x }, 0)",
+		`[sort([3, 1, 2]), sort(["b", "a"], fn(a, b) { a > b }), slice([1, 2, 3], -2), concat([1], [2]), reverse(range(3))]`,
+		`[contains([1, 2], 2), index_of(["a", "b"], "b"), zip([1, 2], [3, 4]), flatten([[1], [2, [3]]])]`,
+		"map(range(5), fn(n) { reduce(map(range(n), fn(x) { x * x }), fn(a, x) { a + x }, 0) })",
+		"map([1, 2], fn(x) { if (x == 2) { return x + true } x })",
+		"sort([1, 2], fn(a, b) { a + true })",
+		"let g = fn() { for (x in [1, 2]) { map([x], fn(y) { return y }) } }; g()",
+		"map([1], fn(x, y) { x })",
+		`[split("a,b", ","), split(" a b "), join(range(3), "-"), trim(" x "), upper("é"), lower("A"), replace("aa", "a", "b")]`,
+		`[contains("abc", "b"), starts_with("abc", "a"), ends_with("abc", "c"), index_of("héllo", "l"), substr("héllo", 1, 3), repeat("-", 3), chars("ab")]`,
+		`[format("%s: %05.1f %v", "x", 2, "y"), parse_int("ff", 16), parse_int("x")]`,
+		`format("%d", "a")`,
+		"fn() { let r = len([1]); let len = 5; [r, len] }()",
+		"let r = len([1, 2]); let len = fn(x) { 0 }; [r, len([1])]",
+		"fn() { let f = fn() { len }; let len = 5; f() }()",
+		"fn() { let r = missing; let missing = 5; r }()",
+		"x = 5; let x = 1; x",
+		"fn() { x += 1; let x = 0; x }()",
+		"len = 1; let len = 2",
+		"c = 1; const c = 2",
+		"let f = fn() { x = 2 }; let x = 1; f(); x",
+		"let i = 0; let r = 0; while (i < 2) { if (i == 1) { y = 5; r = y }; let y = 1; i += 1 }; r",
+		`let k = [1, {"a": [2]}]; [{k: 1}[[1.0, {"a": [2]}]], {[1]: 1}[[2]], keys({[2]: 1, [1]: 2, {}: 3})]`,
+		"let f = fn() { 1 }; [{f: 1}[f], {fn() { 1 }: 1}[fn() { 1 }], {len: 1}[len], {fn() { }(): 1}[fn() { }()]]",
+		`[1 == "1", [1] != {}, {}["a"] == 0, {}["a"] == {}["b"]]`,
+		// Errors.
+		"5 + true",
+		`1 < "1"`,
+		"-true",
+		"foobar",
+		"fn(x) { x + y }(1)",
+		"fn(x) { x }(1, 2)",
+		"1(2)",
+		"{range(1): 1}",
+		"{[1, range(1)]: 1}",
+		"len(1)",
+		"1 / 0",
+		"5 % (2 - 2)",
+		"1.5 / 0",
+		"100000000000000000000 % 0",
+		"fn() { }() + 1",
+		"!fn() { }()",
+	}
+
+	for _, input := range tests {
+		expected := evaluator.Eval(object.NewEnvironment(), parse(input))
+		actual := run(t, input)
+
+		if inspect(actual) != inspect(expected) {
+			t.Errorf("%q results in %s, want %s", input, inspect(actual), inspect(expected))
+		}
+	}
+}
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"foobar", "1:1"},
+		{"let x = 1;\nlet y = x + true;", "2:9"},
+		{"let f = fn(x) {\n  x + y\n};\nf(1)", "2:7"},
+		{"len(1, 2)", "1:1"},
+		{"fn(x) { x }(1, 2)", "1:1"},
+		{"if (true) { [1][-true] }", "1:17"},
+		{"let x = 2;\nmatch (x) { 1 => 1 }", "2:1"},
+		{"fn(x) { match (x) { 1 => 1 } }(2)", "1:9"},
+	}
+
+	for _, tt := range tests {
+		obj := run(t, tt.input)
+		errobj, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("%q results in %T, want *object.Error", tt.input, obj)
+			continue
+		}
+		if errobj.Pos.String() != tt.expected {
+			t.Errorf("error position of %q is %s, want %s",
+				tt.input, errobj.Pos, tt.expected)
+		}
+	}
+}
+
+func TestUnboundVariables(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn() { let r = x; let x = 0; r }()", "identifier not found: x"},
+		{"x = 5; let x = 1; x", "cannot assign to undeclared variable: x"},
+		{"fn() { x += 1; let x = 0; x }()", "cannot assign to undeclared variable: x"},
+		{"fn() { let f = fn() { x = 1 }; f(); let x = 0 }()", "cannot assign to undeclared variable: x"},
+	}
+
+	for _, tt := range tests {
+		obj := run(t, tt.input)
+		errobj, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("%q results in %T, want *object.Error", tt.input, obj)
+			continue
+		}
+		if errobj.Message != tt.expected {
+			t.Errorf("error of %q is %q, want %q", tt.input, errobj.Message, tt.expected)
+		}
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	inputs := []string{
+		`let inner = fn(x) {
+	x + missing
+}
+let outer = fn(x) {
+	let y = 1;
+	inner(x) + y
+}
+outer(1)`,
+		`let f = fn(xs) {
+	map(xs, fn(x) { x + missing })
+}
+f([1])`,
+		`let g = fn(x) { x(1) }
+let f = fn(x) { g(x) }
+f(2)`,
+		`let g = fn(x) { return x + missing; }
+let f = fn(x) { if (x) { g(x) } else { 0 } }
+let h = fn(x) { f(x) + 1 }
+h(2)`,
+	}
+
+	for _, input := range inputs {
+		l := lexer.NewFile("test.monkey", input)
+		p := parser.New(l)
+		program := p.ParseProgram()
+
+		expected := evaluator.Eval(object.NewEnvironment(), program).(*object.Error)
+
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		errobj, ok := New(c.Bytecode()).Run().(*object.Error)
+		if !ok {
+			t.Fatalf("result is not an *object.Error")
+		}
+
+		if errobj.StackTrace() != expected.StackTrace() {
+			t.Errorf("errobj.StackTrace() is\n%s\nwant\n%s",
+				errobj.StackTrace(), expected.StackTrace())
+		}
+	}
+}
+
+func TestCallDepth(t *testing.T) {
+	tests := []string{
+		"let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } }; count(100000, 0)",
+		"let count = fn(n, acc) { match (n) { 0 => acc, _ => count(n - 1, acc + 1) } }; count(100000, 0)",
+		"let count = fn(n) { if (n > 0) { return count(n - 1); } len(\"done\") }; count(100000)",
+		"let f = fn(n) { if (n == 0) { len(1) } else { f(n - 1) } }; f(100000)",
+		"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(9999)",
+		"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(20000)",
+		"let f = fn(n) { 1 + f(n) }; f(1)",
+		"let f = fn(n) { map([n], f) }; f(1)",
+	}
+
+	for _, input := range tests {
+		expected := evaluator.Eval(object.NewEnvironment(), parse(input))
+		actual := run(t, input)
+
+		if inspect(actual) != inspect(expected) {
+			t.Errorf("%q results in %s, want %s", input, inspect(actual), inspect(expected))
+		}
+	}
+}
+
+func TestStrictMode(t *testing.T) {
+	tests := []string{
+		"[1, 2, 3][-3]",
+		"[1, 2, 3][3]",
+		`"héllo"[-6]`,
+		"[1, 2, 3][1:3]",
+		"[1, 2, 3][:4]",
+		`"abc"[2:1]`,
+	}
+
+	for _, input := range tests {
+		program := parse(input)
+
+		e := evaluator.New()
+		e.SetStrict(true)
+		expected := e.Eval(object.NewEnvironment(), program)
+
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		machine := New(c.Bytecode())
+		machine.SetStrict(true)
+		actual := machine.Run()
+
+		if inspect(actual) != inspect(expected) {
+			t.Errorf("%q results in %s, want %s", input, inspect(actual), inspect(expected))
+		}
+	}
+}
+
+func TestGlobalsBetweenRuns(t *testing.T) {
+	symbols := compiler.NewSymbolTable()
+	constants := []object.Object{}
+	globals := make([]object.Object, GlobalsSize)
+
+	var result object.Object
+	for _, input := range []string{"let a = 1;", "let b = a + 1;", "a + b"} {
+		c := compiler.NewWithState(symbols, constants)
+		if err := c.Compile(parse(input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		bytecode := c.Bytecode()
+		constants = bytecode.Constants
+		result = NewWithGlobals(bytecode, globals).Run()
+	}
+
+	if inspect(result) != "3" {
+		t.Errorf("result is %s, want 3", inspect(result))
+	}
+}
+
+// Helper functions for testing.
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func run(t *testing.T, input string) object.Object {
+	t.Helper()
+
+	c := compiler.New()
+	if err := c.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return New(c.Bytecode()).Run()
+}
+
+// inspect treats a missing value as nil; the evaluator results in no value
+// for empty blocks, but the VM always pushes one.
+func inspect(obj object.Object) string {
+	if obj == nil {
+		return "nil"
+	}
+	return obj.Inspect()
+}